@@ -7,17 +7,23 @@ package main
 
 import (
 	// Stdlib
-	"encoding/binary"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 
 	// Salsa
+	"github.com/tchap/salsa/utils/archiver"
+	"github.com/tchap/salsa/utils/crxutil"
 	"github.com/tchap/salsa/utils/flagutil"
 	"github.com/tchap/salsa/utils/httputil"
 
@@ -26,7 +32,11 @@ import (
 	"gopkg.in/yaml.v1"
 )
 
-const crxURLTemplate = "https://clients2.google.com/service/update2/crx?response=redirect&x=id%3D~~~~%26uc"
+// crxURLTemplate is the Chrome Web Store download URL. The store serves CRX3
+// files only to the clients that accept the format and report a recent
+// enough version of Chrome.
+const crxURLTemplate = "https://clients2.google.com/service/update2/crx?response=redirect" +
+	"&acceptformat=crx2,crx3&prodversion=100.0&x=id%3D~~~~%26uc"
 
 // Subcommand initialisation and registration.
 func init() {
@@ -60,6 +70,9 @@ func init() {
   Download the extensions identified by EXTENSION_ID and save it in FILENAME.
   In case EXTENSION_ID is actually a URL, the address is used to retrieve the
   package directly.
+
+  -zip strips the CRX header so that FILENAME is a plain zip archive.
+  Both CRX2 and CRX3 files are supported.
 		`,
 		Action: runGetCrx,
 	}
@@ -83,6 +96,43 @@ func init() {
 	genPackageJson.Flags.Var(packageJsonDeps, "dep", "add a dependency into package.json")
 	chromeExt.MustRegisterSubcommand(genPackageJson)
 
+	pack := &gocli.Command{
+		UsageLine: `
+  pack -key KEY_FILE [-format {crx2|crx3}] SRC_DIR OUT_FILE`,
+		Short: "pack and sign Chrome extensions",
+		Long: `
+  Pack the extension in SRC_DIR into a zip archive, sign it using the RSA
+  private key in KEY_FILE and save the resulting CRX file in OUT_FILE.
+  The extension ID is printed.
+
+  KEY_FILE is a PEM-encoded PKCS #8 or PKCS #1 private key. In case the file
+  does not exist, a new 2048-bit key is generated and saved there. Keep the
+  key safe, the extension ID is derived from it.
+
+  -format selects the CRX format, crx3 by default. Chrome does not install
+  crx2 files since version 73.
+		`,
+		Action: runPack,
+	}
+	pack.Flags.StringVar(&packKey, "key", packKey, "private key file")
+	pack.Flags.StringVar(&packFormat, "format", packFormat, "CRX format")
+	chromeExt.MustRegisterSubcommand(pack)
+
+	verify := &gocli.Command{
+		UsageLine: `
+  verify [-id EXTENSION_ID] FILE`,
+		Short: "verify Chrome extension signatures",
+		Long: `
+  Verify the signatures of the CRX file FILE and print the extension ID.
+  Both CRX2 and CRX3 files are supported. In case -id is set, the extension
+  ID must match as well. The command fails unless everything checks out, so
+  it can be used to verify the extensions downloaded using get_crx.
+		`,
+		Action: runVerify,
+	}
+	verify.Flags.StringVar(&verifyID, "id", verifyID, "expected extension ID")
+	chromeExt.MustRegisterSubcommand(verify)
+
 	getApp().MustRegisterSubcommand(chromeExt)
 }
 
@@ -115,7 +165,18 @@ func runGetCrx(cmd *gocli.Command, args []string) {
 	}
 
 	// Download CRX.
-	resp, err := httputil.Get(packageURL, nil)
+	loadConfig()
+	transport, err := config.Transport()
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	client := &httputil.Client{
+		Transport:      transport,
+		ProgressOutput: config.ProgressOutput(),
+		Limits:         config.Limits(),
+	}
+	resp, err := client.Get(packageURL)
 	if err != nil {
 		log.Fatalf("Error: failed to download crx: %v\n", err)
 	}
@@ -125,54 +186,13 @@ func runGetCrx(cmd *gocli.Command, args []string) {
 	defer resp.Body.Close()
 
 	// Convert CRX to ZIP if requested.
+	var body io.Reader = resp.Body
 	if convertCrxToZip {
-		var (
-			publicKeyLen uint32
-			signatureLen uint32
-		)
-
-		// Drop magic number.
-		if err := binary.Read(resp.Body, binary.LittleEndian, &publicKeyLen); err != nil {
-			log.Fatal(err)
-		}
-		// Drop version.
-		if err := binary.Read(resp.Body, binary.LittleEndian, &publicKeyLen); err != nil {
-			log.Fatal(err)
-		}
-		// Read public key length.
-		if err := binary.Read(resp.Body, binary.LittleEndian, &publicKeyLen); err != nil {
-			log.Fatal(err)
-		}
-		// Read signature length.
-		if err := binary.Read(resp.Body, binary.LittleEndian, &signatureLen); err != nil {
-			log.Fatal(err)
-		}
-
-		var p []byte
-		if publicKeyLen > signatureLen {
-			p = make([]byte, publicKeyLen)
-		} else {
-			p = make([]byte, signatureLen)
-		}
-
-		// Drop the public key.
-		b := p[:publicKeyLen]
-		for i := uint32(0); i != publicKeyLen; {
-			n, err := resp.Body.Read(b[i:])
-			if err != nil {
-				log.Fatal(err)
-			}
-			i += uint32(n)
-		}
-		// Drop the signature.
-		b = p[:signatureLen]
-		for i := uint32(0); i != signatureLen; {
-			n, err := resp.Body.Read(b[i:])
-			if err != nil {
-				log.Fatal(err)
-			}
-			i += uint32(n)
+		crx, err := crxutil.NewCrxFile(resp.Body)
+		if err != nil {
+			log.Fatalf("Error: failed to read crx: %v\n", err)
 		}
+		body = crx.ZipFile
 	}
 
 	// Write it to the file.
@@ -182,7 +202,7 @@ func runGetCrx(cmd *gocli.Command, args []string) {
 	}
 	defer file.Close()
 
-	n, err := io.Copy(file, resp.Body)
+	n, err := io.Copy(file, body)
 	if err != nil {
 		log.Fatalf("Error: %v\n", err)
 	}
@@ -219,7 +239,10 @@ func runGenPackageJson(cmd *gocli.Command, args []string) {
 	packageJson.Name = strings.ToLower(packageJson.Name)
 	packageJson.Name = strings.Replace(packageJson.Name, " ", "-", -1)
 	packageJson.Dependencies = packageJsonDeps.M
-	toPackageJsonVersion(&packageJson.Version)
+	packageJson.Version, err = toPackageJsonVersion(packageJson.Version)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
 
 	content, err = json.MarshalIndent(packageJson, "", "  ")
 	if err != nil {
@@ -239,6 +262,198 @@ func runGenPackageJson(cmd *gocli.Command, args []string) {
 	fmt.Println("package.json created")
 }
 
+// Subcommand flags.
+var (
+	packKey    string
+	packFormat = "crx3"
+)
+
+// Subcommand handler.
+func runPack(cmd *gocli.Command, args []string) {
+	if len(args) != 2 || packKey == "" {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	srcDir, filename := args[0], args[1]
+
+	var version uint32
+	switch packFormat {
+	case "crx2":
+		version = crxutil.Version2
+	case "crx3":
+		version = crxutil.Version3
+	default:
+		log.Fatalf("Error: unknown format: %v\n", packFormat)
+	}
+
+	// Make sure there is an extension to pack.
+	if _, err := os.Stat(filepath.Join(srcDir, "manifest.json")); err != nil {
+		log.Fatalf("Error: %v is not an extension directory: %v\n", srcDir, err)
+	}
+
+	// Load the key, generate it if necessary.
+	key, err := loadPrivateKey(packKey)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalf("Error: %v\n", err)
+		}
+		if config.Dry() {
+			fmt.Printf("Would generate %v\n", packKey)
+			fmt.Printf("Would pack %v into %v\n", srcDir, filename)
+			return
+		}
+		if key, err = generatePrivateKey(packKey); err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+		fmt.Printf("Generated %v\n", packKey)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	id := crxutil.ExtensionID(publicKey)
+
+	if config.Dry() {
+		fmt.Printf("Would pack %v into %v\n", srcDir, filename)
+		fmt.Println(id)
+		return
+	}
+
+	// Pack the extension directory.
+	zipArchiver, err := archiver.New(archiver.ZipArchiverType, config)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	archive, err := zipArchiver.Archive(srcDir)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	defer func() {
+		archive.Close()
+		os.Remove(archive.Name())
+	}()
+
+	// Write the CRX file.
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	if err := crxutil.WriteCrxFile(file, archive, version, key); err != nil {
+		file.Close()
+		os.Remove(filename)
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(filename)
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	fmt.Println(id)
+}
+
+var verifyID string
+
+// Subcommand handler.
+func runVerify(cmd *gocli.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	filename := args[0]
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	defer file.Close()
+
+	crx, err := crxutil.NewCrxFile(file)
+	if err != nil {
+		log.Fatalf("Error: %v: %v\n", filename, err)
+	}
+
+	id, err := crxutil.Verify(crx)
+	if err != nil {
+		log.Fatalf("Error: %v: %v\n", filename, err)
+	}
+
+	if verifyID != "" && id != verifyID {
+		log.Fatalf("Error: %v: extension ID mismatch: expected %v, got %v\n",
+			filename, verifyID, id)
+	}
+
+	fmt.Println(id)
+}
+
+// loadPrivateKey reads the PEM-encoded RSA private key from filename.
+func loadPrivateKey(filename string) (*rsa.PrivateKey, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%v: no PEM data found", filename)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filename, err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%v: not an RSA private key", filename)
+		}
+		return rsaKey, nil
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filename, err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%v: unsupported PEM block type %v", filename, block.Type)
+	}
+}
+
+// generatePrivateKey generates a new RSA private key and saves it
+// into filename, PEM-encoded in the PKCS #8 format Chrome uses.
+func generatePrivateKey(filename string) (*rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		file.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(filename)
+		return nil, err
+	}
+	return key, nil
+}
+
 func getNameFromManifest(cmd *gocli.Command, args []string) {
 	if len(args) != 1 {
 		cmd.Usage()
@@ -264,8 +479,11 @@ func getVersionFromManifest(cmd *gocli.Command, args []string) {
 		log.Fatalf("Error: %v\n", err)
 	}
 
-	toPackageJsonVersion(&manifest.Version)
-	fmt.Print(manifest.Version)
+	version, err := toPackageJsonVersion(manifest.Version)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	fmt.Print(version)
 }
 
 type manifest struct {
@@ -0,0 +1,308 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/cryptoutil"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+const (
+	// DefaultKDFIterations is the number of PBKDF2 iterations used for newly
+	// encrypted configuration files.
+	DefaultKDFIterations = 200000
+
+	// KDFSaltLength is the length of the PBKDF2 salt in bytes.
+	KDFSaltLength = 16
+)
+
+// EncryptionConfig is the "encryption" object of .salsarc. It specifies how
+// to get the key to decrypt the encrypted passwords and secrets with.
+// Either a passphrase is used to derive the key, or a key file is read.
+type EncryptionConfig struct {
+	Salt       string `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	KeyFile    string `json:"keyFile,omitempty"`
+}
+
+// Subcommand initialisation and registration.
+func init() {
+	cfg := &gocli.Command{
+		UsageLine: `
+  config SUBCMD`,
+		Short: "manage the user-specific configuration file",
+	}
+
+	encrypt := &gocli.Command{
+		UsageLine: `
+  encrypt [-key_file FILE]`,
+		Short: "encrypt passwords and secrets in the user-specific .salsarc",
+		Long: `
+  Encrypt all the passwords and project secrets stored in the user-specific
+  .salsarc using AES-GCM. Values that are encrypted already are left intact,
+  so encrypt can be run again after a plaintext value is added to the file.
+
+  The key is derived from a passphrase that is either read from the terminal
+  or from SALSA_PASSPHRASE. In case -key_file is set, the key is computed from
+  the content of FILE instead. The key file path is saved in .salsarc.
+
+  Salsa decrypts the values automatically every time the configuration is
+  loaded, asking for the passphrase or using SALSA_PASSPHRASE if it is set.
+
+ENVIRONMENTAL VARIABLES:
+  SALSA_PASSPHRASE - passphrase to use instead of asking for it
+		`,
+		Action: runConfigEncrypt,
+	}
+	encrypt.Flags.StringVar(&configKeyFile, "key_file", configKeyFile,
+		"use the key file instead of a passphrase")
+	cfg.MustRegisterSubcommand(encrypt)
+
+	getApp().MustRegisterSubcommand(cfg)
+}
+
+var configKeyFile string
+
+// Subcommand handler.
+func runConfigEncrypt(cmd *gocli.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	var count int
+	err := updateUserConfig(func(rc map[string]interface{}) error {
+		enc, err := rcEncryptionConfig(rc)
+		if err != nil {
+			return err
+		}
+
+		// Set up the encryption unless already set up.
+		if enc == nil {
+			enc = new(EncryptionConfig)
+			if configKeyFile != "" {
+				enc.KeyFile = configKeyFile
+			} else {
+				salt, err := cryptoutil.NewSalt(KDFSaltLength)
+				if err != nil {
+					return err
+				}
+				enc.Salt = base64.StdEncoding.EncodeToString(salt)
+				enc.Iterations = DefaultKDFIterations
+			}
+			rc["encryption"] = enc
+		} else if configKeyFile != "" && configKeyFile != enc.KeyFile {
+			return errors.New("the file is already encrypted using a different key")
+		}
+
+		key, err := encryptionKey(enc, true)
+		if err != nil {
+			return err
+		}
+
+		count, err = encryptRC(rc, key)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Error: failed to encrypt %v: %v", userConfigPath(), err)
+	}
+
+	fmt.Printf("%v values encrypted in %v\n", count, userConfigPath())
+}
+
+// encryptRC encrypts the passwords and secrets in rc, which is the generic
+// JSON representation of .salsarc. It returns the number of encrypted values.
+func encryptRC(rc map[string]interface{}, key []byte) (int, error) {
+	var count int
+	encrypt := func(obj map[string]interface{}, k string) error {
+		value, ok := obj[k].(string)
+		if !ok || value == "" {
+			return nil
+		}
+		if cryptoutil.IsEncrypted(value) {
+			// Make sure the same key is being used for all the values.
+			_, err := cryptoutil.Decrypt(key, value)
+			return err
+		}
+
+		encrypted, err := cryptoutil.Encrypt(key, value)
+		if err != nil {
+			return err
+		}
+		obj[k] = encrypted
+		count++
+		return nil
+	}
+
+	encryptObject := func(obj map[string]interface{}) error {
+		if err := encrypt(obj, "password"); err != nil {
+			return err
+		}
+		if secrets, ok := obj["secrets"].(map[string]interface{}); ok {
+			for project := range secrets {
+				if err := encrypt(secrets, project); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := encryptObject(rc); err != nil {
+		return 0, err
+	}
+	if stores, ok := rc["stores"].(map[string]interface{}); ok {
+		for _, store := range stores {
+			if store, ok := store.(map[string]interface{}); ok {
+				if err := encryptObject(store); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// rcEncryptionConfig returns the encryption config contained in rc,
+// which is the generic JSON representation of .salsarc.
+func rcEncryptionConfig(rc map[string]interface{}) (*EncryptionConfig, error) {
+	obj, ok := rc["encryption"]
+	if !ok || obj == nil {
+		return nil, nil
+	}
+
+	content, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var enc EncryptionConfig
+	if err := json.Unmarshal(content, &enc); err != nil {
+		return nil, err
+	}
+	return &enc, nil
+}
+
+// The key is cached so that the passphrase is asked for just once.
+var cachedEncryptionKey []byte
+
+// encryptionKey returns the key as specified by enc. The passphrase is read
+// from SALSA_PASSPHRASE or from the terminal, in which case it is asked for
+// twice when confirm is set.
+func encryptionKey(enc *EncryptionConfig, confirm bool) ([]byte, error) {
+	if cachedEncryptionKey != nil {
+		return cachedEncryptionKey, nil
+	}
+
+	if enc.KeyFile != "" {
+		content, err := ioutil.ReadFile(enc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cachedEncryptionKey = cryptoutil.KeyFromFile(content)
+		return cachedEncryptionKey, nil
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(enc.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("invalid encryption salt")
+	}
+	if enc.Iterations <= 0 {
+		return nil, errors.New("invalid number of KDF iterations")
+	}
+
+	passphrase := os.Getenv("SALSA_PASSPHRASE")
+	if passphrase == "" {
+		passphrase, err = readSecretLine("Passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if confirm {
+			again, err := readSecretLine("Passphrase (again): ")
+			if err != nil {
+				return nil, err
+			}
+			if again != passphrase {
+				return nil, errors.New("passphrases do not match")
+			}
+		}
+		if passphrase == "" {
+			return nil, errors.New("empty passphrase")
+		}
+	}
+
+	cachedEncryptionKey = cryptoutil.DeriveKey(passphrase, salt, enc.Iterations)
+	return cachedEncryptionKey, nil
+}
+
+// decryptConfig decrypts the encrypted values in config.RC in place.
+// The key is only requested when there is something to decrypt.
+func decryptConfig() error {
+	var key []byte
+	decrypt := func(value *string) error {
+		if !cryptoutil.IsEncrypted(*value) {
+			return nil
+		}
+		if key == nil {
+			if config.RC.Encryption == nil {
+				return errors.New(`encrypted values found, but "encryption" is not set`)
+			}
+			var err error
+			key, err = encryptionKey(config.RC.Encryption, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		plaintext, err := cryptoutil.Decrypt(key, *value)
+		if err != nil {
+			return err
+		}
+		*value = plaintext
+		return nil
+	}
+
+	decryptSecrets := func(secrets map[string]string) error {
+		for project, secret := range secrets {
+			if err := decrypt(&secret); err != nil {
+				return err
+			}
+			secrets[project] = secret
+		}
+		return nil
+	}
+
+	if err := decrypt(&config.RC.Password); err != nil {
+		return err
+	}
+	if err := decryptSecrets(config.RC.Secrets); err != nil {
+		return err
+	}
+	for _, store := range config.RC.Stores {
+		if store == nil {
+			continue
+		}
+		if err := decrypt(&store.Password); err != nil {
+			return err
+		}
+		if err := decryptSecrets(store.Secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,13 +7,23 @@ package main
 
 import (
 	// Stdlib
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"regexp"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"text/template/parse"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/flagutil"
+	"github.com/tchap/salsa/utils/winres"
 
 	// Others
 	"github.com/dmotylev/nutrition"
@@ -31,32 +41,175 @@ func init() {
 
 	genBhoversionRc := &gocli.Command{
 		UsageLine: `
-  gen_bhoversion_rc [-manifest MANIFEST_FILE] [FILE]`,
+  gen_bhoversion_rc [-manifest MANIFEST_FILE] [-template TEMPLATE_FILE]
+                    [-config CONFIG_FILE] [-internal_name NAME]
+                    [-original_filename NAME] [-file_type {DLL|EXE}]
+                    [-language LANGID] [-codepage CODEPAGE]
+                    [-value KEY:VALUE ...]
+                    [-build_strategy {add|replace|encode}]
+                    [-format {rc|res|syso}]
+                    [-arch {386|amd64|arm64}] [FILE]`,
 		Short: "generate bhoversion.rc",
 		Long: `
   gen_brhoversion_rc generates bhoversion.rc in the current working directory
   unless FILE is specified. It can optionally read a Chrome extension
   manifest.json to get the extension version.
 
-  This subcommand uses environmental variables to fill the bhoversion.rc template.
-  The required environmental variables are:
-    BHRC_COMPANYNAME
-    BHRC_FILEDESCRIPTION
-    BHRC_VERSION - must be a.b.c.d, $BUILD_NUMBER is used for $d if present;
-                   does not have to be set if manifest is being used
-    BHRC_LEGALCOPYRIGHT
-    BHRC_PRODUCTNAME
+  The VERSIONINFO fields are read from CONFIG_FILE, a JSON or YAML file, then
+  from the environmental variables and then from the command line flags, every
+  source overwriting the values set by the previous one. The fields are:
+
+    Config key        Environmental variable  Flag
+    companyName       BHRC_COMPANYNAME
+    fileDescription   BHRC_FILEDESCRIPTION
+    version           BHRC_VERSION
+    legalCopyright    BHRC_LEGALCOPYRIGHT
+    productName       BHRC_PRODUCTNAME
+    internalName      BHRC_INTERNALNAME       -internal_name
+    originalFilename  BHRC_ORIGINALFILENAME   -original_filename
+    fileType          BHRC_FILETYPE           -file_type
+    language          BHRC_LANGUAGE           -language
+    codepage          BHRC_CODEPAGE           -codepage
+    values            BHRC_VALUE_$key         -value
+
+  The version can be a, a.b, a.b.c, a.b.c.d or a semantic version a.b.c-d,
+  every part being a number between 0 and 65535. The missing parts are 0.
+  It does not have to be set if manifest is being used. The build number,
+  see publish for how it is detected, is mapped into the version as
+  specified by -build_strategy:
+    * add     - add the build number to $d, 1.2.3.4 + 92 = 1.2.3.96 (default)
+    * replace - replace $d with the build number, 1.2.3.4 + 92 = 1.2.3.92
+    * encode  - store the build number in $c and $d as the high and the low
+                16 bits, 1.2 + 70000 = 1.2.1.4464, $c and $d must be 0
+  The resulting parts must not exceed 65535, otherwise no file is written.
+
+  fileType is DLL or EXE, DLL by default. language is the hexadecimal language
+  ID, 0409 (U.S. English) by default, codepage is the decimal codepage, 1252
+  by default. originalFilename is ancho.dll unless set, internalName is the
+  same as originalFilename unless set. values are extra StringFileInfo values,
+  e.g. -value "Comments:Nightly build"
+
+  TEMPLATE_FILE is a Go text/template to be used instead of the built-in one.
+  The template can use the fields above as well as the computed fields:
+    .VersionCommas - the version with commas instead of dots, e.g. 1,2,3,4
+    .FileTypeCode  - FILETYPE value, e.g. 0x2L for DLL
+    .LangCodepage  - StringFileInfo block name, e.g. 040904e4
+    .Translation   - VarFileInfo Translation value, e.g. 0x409, 1252
+    .ExtraValues   - the extra values sorted by key, each with .Key and .Value
+  The rc function quotes and escapes a string value, e.g. {{rc .ProductName}}.
+  All the fields the template uses must be set, otherwise no file is written.
+
+  -format selects the output format:
+    * rc   - resource script to be compiled by rc.exe (the default)
+    * res  - compiled resource file, bhoversion.res by default
+    * syso - COFF object file to be linked into a Go binary by the Go linker,
+             rsrc_windows_$arch.syso by default, see -arch
+  The res and syso files contain the same VERSIONINFO resource the built-in
+  template describes, so no resource compiler is needed. -template cannot be
+  used with these formats.
 		`,
 		Action: runGenBhoversionRc,
 	}
 	genBhoversionRc.Flags.StringVar(&manifestJson, "manifest", manifestJson,
 		"read version from manifest.json")
+	genBhoversionRc.Flags.StringVar(&bhoTemplate, "template", bhoTemplate,
+		"use the template file instead of the built-in template")
+	genBhoversionRc.Flags.StringVar(&bhoConfig, "config", bhoConfig,
+		"read the fields from the JSON or YAML file")
+	genBhoversionRc.Flags.StringVar(&bhoFlags.InternalName, "internal_name", "",
+		"InternalName value")
+	genBhoversionRc.Flags.StringVar(&bhoFlags.OriginalFilename, "original_filename", "",
+		"OriginalFilename value")
+	genBhoversionRc.Flags.StringVar(&bhoFlags.FileType, "file_type", "",
+		"file type, DLL or EXE")
+	genBhoversionRc.Flags.StringVar(&bhoFlags.Language, "language", "",
+		"hexadecimal language ID")
+	genBhoversionRc.Flags.StringVar(&bhoFlags.Codepage, "codepage", "",
+		"decimal codepage")
+	genBhoversionRc.Flags.Var(bhoValues, "value",
+		"add an extra StringFileInfo value")
+	genBhoversionRc.Flags.StringVar(&bhoBuildStrategy, "build_strategy", bhoBuildStrategy,
+		"how to map the build number into the version")
+	genBhoversionRc.Flags.StringVar(&bhoFormat, "format", bhoFormat,
+		"output format")
+	genBhoversionRc.Flags.StringVar(&bhoArch, "arch", bhoArch,
+		"architecture of the syso file")
 	ieExt.MustRegisterSubcommand(genBhoversionRc)
 
+	registerBhoComCommands(ieExt)
+
 	getApp().MustRegisterSubcommand(ieExt)
 }
 
-var manifestJson string
+// Subcommand flags.
+var (
+	manifestJson     string
+	bhoTemplate      string
+	bhoConfig        string
+	bhoFlags         versionInfoFields
+	bhoValues        = flagutil.NewMapValue()
+	bhoFormat        = "rc"
+	bhoBuildStrategy = string(winres.BuildAdd)
+	bhoArch          = "amd64"
+)
+
+// versionInfoFields are the VERSIONINFO fields as collected from the config
+// file, the environment and the command line flags.
+type versionInfoFields struct {
+	CompanyName      string `yaml:"companyName"`
+	FileDescription  string `yaml:"fileDescription"`
+	Version          string `yaml:"version"`
+	LegalCopyright   string `yaml:"legalCopyright"`
+	ProductName      string `yaml:"productName"`
+	InternalName     string `yaml:"internalName"`
+	OriginalFilename string `yaml:"originalFilename"`
+	FileType         string `yaml:"fileType"`
+	Language         string `yaml:"language"`
+	Codepage         string `yaml:"codepage"`
+
+	Values map[string]string `yaml:"values"`
+}
+
+// merge sets the fields that are set in other, which is a pointer to a struct
+// with the same field names. The values maps are merged.
+func (fields *versionInfoFields) merge(other interface{}) {
+	dst := reflect.ValueOf(fields).Elem()
+	src := reflect.ValueOf(other).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		v := src.Field(i)
+		switch v.Kind() {
+		case reflect.String:
+			if v.String() != "" {
+				dst.FieldByName(src.Type().Field(i).Name).Set(v)
+			}
+		case reflect.Map:
+			for _, k := range v.MapKeys() {
+				if fields.Values == nil {
+					fields.Values = make(map[string]string)
+				}
+				fields.Values[k.String()] = v.MapIndex(k).String()
+			}
+		}
+	}
+}
+
+// versionInfo is the template context, the fields plus the computed values.
+type versionInfo struct {
+	versionInfoFields
+
+	VersionCommas string
+	FileTypeCode  string
+	LangCodepage  string
+	Translation   string
+	ExtraValues   []versionInfoValue
+
+	// fileVersion is the version with the build number mapped into it.
+	fileVersion [4]uint16
+}
+
+type versionInfoValue struct {
+	Key, Value string
+}
 
 // Subcommand handler.
 func runGenBhoversionRc(cmd *gocli.Command, args []string) {
@@ -66,10 +219,21 @@ func runGenBhoversionRc(cmd *gocli.Command, args []string) {
 	}
 
 	var filename string
+	switch bhoFormat {
+	case "rc":
+		filename = "bhoversion.rc"
+	case "res":
+		filename = "bhoversion.res"
+	case "syso":
+		filename = "rsrc_windows_" + bhoArch + ".syso"
+	default:
+		log.Fatalf("Error: unknown format: %v\n", bhoFormat)
+	}
+	if bhoFormat != "rc" && bhoTemplate != "" {
+		log.Fatalf("Error: -template cannot be used with -format %v\n", bhoFormat)
+	}
 	if len(args) == 1 {
 		filename = args[0]
-	} else {
-		filename = "bhoversion.rc"
 	}
 
 	var version string
@@ -90,71 +254,169 @@ func runGenBhoversionRc(cmd *gocli.Command, args []string) {
 		version = manifest.Version
 	}
 
-	var ctx struct {
-		CompanyName     string
-		FileDescription string
-		Version         string
-		VersionCommas   string
-		LegalCopyright  string
-		ProductName     string
+	// Collect the fields: the config file, then the environment, then flags.
+	var fields versionInfoFields
+
+	if bhoConfig != "" {
+		content, err := ioutil.ReadFile(bhoConfig)
+		if err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+
+		var cfg versionInfoFields
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			log.Fatalf("Error: failed to unmarshal %v: %v\n", bhoConfig, err)
+		}
+		fields.merge(&cfg)
 	}
 
-	if err := nutrition.Env("BHRC_").Feed(&ctx); err != nil {
+	// nutrition is only fed the string fields.
+	var env struct {
+		CompanyName, FileDescription, Version, LegalCopyright, ProductName string
+		InternalName, OriginalFilename, FileType, Language, Codepage       string
+	}
+	if err := nutrition.Env("BHRC_").Feed(&env); err != nil {
 		log.Fatalf("Error: %v\n", err)
 	}
+	fields.merge(&env)
+
+	envValues := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i != -1 && strings.HasPrefix(kv[:i], "BHRC_VALUE_") {
+			envValues[kv[len("BHRC_VALUE_"):i]] = kv[i+1:]
+		}
+	}
+	fields.merge(&struct{ Values map[string]string }{envValues})
+
+	bhoFlags.Values = bhoValues.M
+	fields.merge(&bhoFlags)
 
 	if version != "" {
-		ctx.Version = version
+		fields.Version = version
+	}
+
+	// Fill in the defaults.
+	if fields.OriginalFilename == "" {
+		fields.OriginalFilename = "ancho.dll"
+	}
+	if fields.InternalName == "" {
+		fields.InternalName = fields.OriginalFilename
+	}
+	if fields.FileType == "" {
+		fields.FileType = "DLL"
+	}
+	if fields.Language == "" {
+		fields.Language = "0409"
+	}
+	if fields.Codepage == "" {
+		fields.Codepage = "1252"
 	}
 
-	switch {
-	case ctx.CompanyName == "":
-		log.Fatalln("Error: BHRC_COMPANYNAME not set")
-	case ctx.FileDescription == "":
-		log.Fatalln("Error: BHRC_FILEDESCRIPTION not set")
-	case ctx.Version == "":
-		log.Fatalln("Error: BHRC_VERSION not set")
-	case ctx.LegalCopyright == "":
-		log.Fatalln("Error: BHRC_LEGALCOPYRIGHT not set")
-	case ctx.ProductName == "":
-		log.Fatalln("Error: BHRC_PRODUCTNAME not set")
+	// Parse the template, the built-in one unless -template is set.
+	var (
+		t   *template.Template
+		err error
+	)
+	if bhoTemplate != "" {
+		t, err = template.New(filepath.Base(bhoTemplate)).Funcs(rcFuncs).ParseFiles(bhoTemplate)
+	} else {
+		t, err = template.New("bhoversion.rc").Funcs(rcFuncs).Parse(bhoversionRcTemplate)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
 	}
 
-	// In case this is a.b.c-d
-	ctx.Version = strings.Replace(ctx.Version, "-", ".", 1)
+	// Make sure all the fields used by the template are set.
+	var (
+		missing []string
+		seen    = make(map[string]bool)
+	)
+	for _, name := range templateFields(t) {
+		if name == "VersionCommas" {
+			name = "Version"
+		}
+		if v := reflect.ValueOf(fields).FieldByName(name); v.IsValid() &&
+			v.Kind() == reflect.String && v.String() == "" && !seen[name] {
 
-	buildNum := os.Getenv("BUILD_NUMBER")
-	if buildNum == "" {
-		buildNum = "0"
+			seen[name] = true
+			missing = append(missing, "BHRC_"+strings.ToUpper(name))
+		}
+	}
+	if len(missing) != 0 {
+		log.Fatalf("Error: not set: %v\n", strings.Join(missing, ", "))
 	}
 
-	// In case we already have a.b.c.d, we make a.b.c.db out of it where
-	// b is the build number. That is the only way that can be used inside
-	// of that IE crap I guess...
-	if strings.Count(ctx.Version, ".") == 3 {
-		ctx.Version += buildNum
-	} else {
-		ctx.Version = fmt.Sprintf("%v.%v", ctx.Version, buildNum)
+	ctx := versionInfo{versionInfoFields: fields}
+
+	// Compute the remaining fields.
+	switch strings.ToUpper(fields.FileType) {
+	case "DLL":
+		ctx.FileTypeCode = "0x2L"
+	case "EXE":
+		ctx.FileTypeCode = "0x1L"
+	default:
+		log.Fatalf("Error: invalid file type: %v\n", fields.FileType)
 	}
 
-	matched, err := regexp.Match("[0-9]+([.][0-9]+){3}", []byte(ctx.Version))
+	lang, err := strconv.ParseUint(strings.TrimPrefix(fields.Language, "0x"), 16, 16)
+	if err != nil {
+		log.Fatalf("Error: invalid language ID: %v\n", fields.Language)
+	}
+	codepage, err := strconv.ParseUint(fields.Codepage, 10, 16)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Error: invalid codepage: %v\n", fields.Codepage)
 	}
-	if !matched {
-		log.Fatalf("Error: invalid version string: %v", ctx.Version)
+	ctx.LangCodepage = fmt.Sprintf("%04x%04x", lang, codepage)
+	ctx.Translation = fmt.Sprintf("0x%x, %d", lang, codepage)
+
+	var keys []string
+	for k := range fields.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ctx.ExtraValues = append(ctx.ExtraValues, versionInfoValue{k, fields.Values[k]})
 	}
 
-	ctx.VersionCommas = strings.Replace(ctx.Version, ".", ",", -1)
+	if ctx.Version != "" {
+		ctx.fileVersion, err = winres.FileVersion(ctx.Version,
+			config.Build().BuildNumber, winres.BuildStrategy(bhoBuildStrategy))
+		if err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+		ctx.Version = winres.FormatFileVersion(ctx.fileVersion, ".")
+		ctx.VersionCommas = winres.FormatFileVersion(ctx.fileVersion, ",")
+	}
 
-	t := template.Must(template.New("bhoversion.rc").Parse(bhoversionRcTemplate))
+	// Render into a buffer first so that no partial file is written.
+	var buf bytes.Buffer
+	switch bhoFormat {
+	case "rc":
+		err = t.Execute(&buf, ctx)
+	case "res", "syso":
+		var vi *winres.VersionInfo
+		vi, err = versionInfoResource(&ctx, uint16(lang), uint16(codepage))
+		if err != nil {
+			break
+		}
+		if bhoFormat == "res" {
+			err = winres.WriteRes(&buf, winres.TypeVersion, winres.VersionInfoID,
+				uint16(lang), vi.Bytes())
+		} else {
+			err = winres.WriteSyso(&buf, bhoArch, winres.TypeVersion, winres.VersionInfoID,
+				uint16(lang), vi.Bytes())
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
 
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatalf("Error: %v\n", err)
 	}
 
-	if err := t.Execute(file, ctx); err != nil {
+	if _, err := file.Write(buf.Bytes()); err != nil {
 		file.Close()
 		log.Fatalf("Error: %v\n", err)
 	}
@@ -164,6 +426,113 @@ func runGenBhoversionRc(cmd *gocli.Command, args []string) {
 	}
 }
 
+// versionInfoResource returns the VERSIONINFO resource the built-in template
+// describes. ctx.fileVersion must be already set.
+func versionInfoResource(ctx *versionInfo, lang, codepage uint16) (*winres.VersionInfo, error) {
+	if ctx.Version == "" {
+		return nil, errors.New("version not set")
+	}
+	version := ctx.fileVersion
+
+	fileType := uint32(winres.FileTypeDLL)
+	if ctx.FileTypeCode == "0x1L" {
+		fileType = winres.FileTypeApp
+	}
+
+	strs := []winres.String{
+		{Key: "CompanyName", Value: ctx.CompanyName},
+		{Key: "FileDescription", Value: ctx.FileDescription},
+		{Key: "FileVersion", Value: ctx.Version},
+		{Key: "InternalName", Value: ctx.InternalName},
+		{Key: "LegalCopyright", Value: ctx.LegalCopyright},
+		{Key: "OriginalFilename", Value: ctx.OriginalFilename},
+		{Key: "ProductName", Value: ctx.ProductName},
+		{Key: "ProductVersion", Value: ctx.Version},
+	}
+	for _, v := range ctx.ExtraValues {
+		strs = append(strs, winres.String{Key: v.Key, Value: v.Value})
+	}
+
+	return &winres.VersionInfo{
+		FileVersion:    version,
+		ProductVersion: version,
+		FileFlagsMask:  winres.FileFlagsMask,
+		FileOS:         winres.FileOSWindows32,
+		FileType:       fileType,
+		Language:       lang,
+		Codepage:       codepage,
+		Strings:        strs,
+	}, nil
+}
+
+// templateFields returns the names of the fields of dot used by t,
+// e.g. CompanyName for {{.CompanyName}}. The fields accessed within range
+// and with are ignored since dot is not the context there.
+func templateFields(t *template.Template) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			if name := n.Ident[0]; !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.ElseList)
+		}
+	}
+
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			walk(tmpl.Tree.Root)
+		}
+	}
+	return names
+}
+
+// rcFuncs are the functions available in the bhoversion.rc templates.
+var rcFuncs = template.FuncMap{
+	// .rc strings are double-quoted, a quote is escaped by doubling it,
+	// a backslash starts an escape sequence.
+	"rc": func(s string) string {
+		s = strings.Replace(s, `\`, `\\`, -1)
+		return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+	},
+}
+
 const bhoversionRcTemplate = `
 1 VERSIONINFO
 FILEVERSION {{.VersionCommas}}
@@ -171,57 +540,27 @@ PRODUCTVERSION {{.VersionCommas}}
 FILEFLAGSMASK 0x3fL
 FILEFLAGS 0x0L
 FILEOS 0x4L
-FILETYPE 0x2L
+FILETYPE {{.FileTypeCode}}
 FILESUBTYPE 0x0L
 BEGIN
     BLOCK "StringFileInfo"
     BEGIN
-        BLOCK "040904e4"
+        BLOCK "{{.LangCodepage}}"
         BEGIN
-            VALUE "CompanyName", "{{.CompanyName}}"
-            VALUE "FileDescription", "{{.FileDescription}}"
-            VALUE "FileVersion", "{{.Version}}"
-            VALUE "InternalName", "ancho.dll"
-            VALUE "LegalCopyright", "{{.LegalCopyright}}"
-            VALUE "OriginalFilename", "ancho.dll"
-            VALUE "ProductName", "{{.ProductName}}"
-            VALUE "ProductVersion", "{{.Version}}"
+            VALUE "CompanyName", {{rc .CompanyName}}
+            VALUE "FileDescription", {{rc .FileDescription}}
+            VALUE "FileVersion", {{rc .Version}}
+            VALUE "InternalName", {{rc .InternalName}}
+            VALUE "LegalCopyright", {{rc .LegalCopyright}}
+            VALUE "OriginalFilename", {{rc .OriginalFilename}}
+            VALUE "ProductName", {{rc .ProductName}}
+            VALUE "ProductVersion", {{rc .Version}}{{range .ExtraValues}}
+            VALUE {{rc .Key}}, {{rc .Value}}{{end}}
         END
     END
     BLOCK "VarFileInfo"
     BEGIN
-        VALUE "Translation", 0x409, 1252
+        VALUE "Translation", {{.Translation}}
     END
 END
 `
-
-/*
-const bhoversionRcTemplate = `
-1 VERSIONINFO
-FILEVERSION {{.VersionCommas}}
-PRODUCTVERSION {{.VersionCommas}}
-FILEOS 0x4
-FILETYPE 0x2
-{
-BLOCK "StringFileInfo"
-{
-        BLOCK "040904b0"
-        {
-                VALUE "CompanyName", "{{.CompanyName}}"
-                VALUE "FileDescription", "{{.FileDescription}}"
-                VALUE "FileVersion", "{{.Version}}"
-                VALUE "InternalName", "ancho.dll"
-                VALUE "LegalCopyright", "{{.LegalCopyright}}"
-                VALUE "OriginalFilename", "ancho.dll"
-                VALUE "ProductName", "{{.ProductName}}"
-                VALUE "ProductVersion", "{{.Version}}"
-        }
-}
-
-BLOCK "VarFileInfo"
-{
-        VALUE "Translation", 0x0409 0x04E4
-}
-}
-`
-*/
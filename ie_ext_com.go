@@ -0,0 +1,386 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode/utf16"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+// registerBhoComCommands registers the subcommands generating the COM
+// registration of the BHO with ieExt.
+func registerBhoComCommands(ieExt *gocli.Command) {
+	const fieldsHelp = `
+  The registration fields are read from the "bho" object in package.json,
+  if present, and then from the command line flags:
+
+    package.json key  Flag
+    clsid             -clsid
+    progId            -prog_id
+    dll               -dll
+    threadingModel    -threading_model
+    description       -description
+
+  clsid is the class ID of the BHO, see gen_clsid. progId is the ProgID, e.g.
+  Ancho.BHO.1. dll is the DLL implementing the BHO, ancho.dll by default.
+  threadingModel is Apartment, Both, Free or Neutral, Apartment by default.
+  description is the name of the BHO, the package name by default.
+`
+
+	genRgs := &gocli.Command{
+		UsageLine: `
+  gen_rgs [-clsid CLSID] [-prog_id PROGID] [-dll DLL]
+          [-threading_model MODEL] [-description TEXT] [FILE]`,
+		Short: "generate ATL registrar script for the BHO",
+		Long: `
+  gen_rgs generates an ATL registrar script registering the BHO COM class and
+  the BHO itself under Browser Helper Objects. The script is written into
+  FILE, bho.rgs in the current working directory by default.
+` + fieldsHelp + `
+  The registrar replaces %MODULE% with the path of the DLL being registered,
+  so dll is not used in the script.
+		`,
+		Action: runGenRgs,
+	}
+	addBhoComFlags(genRgs)
+	ieExt.MustRegisterSubcommand(genRgs)
+
+	genReg := &gocli.Command{
+		UsageLine: `
+  gen_reg [-clsid CLSID] [-prog_id PROGID] [-dll DLL]
+          [-threading_model MODEL] [-description TEXT] [FILE]`,
+		Short: "generate .reg file for the BHO",
+		Long: `
+  gen_reg generates a file to be imported by regedit registering the BHO COM
+  class and the BHO itself under Browser Helper Objects. The file is written
+  into FILE, bho.reg in the current working directory by default.
+` + fieldsHelp + `
+  dll is used as InprocServer32 as it is, so it should be the full path of
+  the DLL unless the DLL is to be found on the search path.
+		`,
+		Action: runGenReg,
+	}
+	addBhoComFlags(genReg)
+	ieExt.MustRegisterSubcommand(genReg)
+
+	genClsid := &gocli.Command{
+		UsageLine: `
+  gen_clsid [-force]`,
+		Short: "generate a new CLSID for the BHO",
+		Long: `
+  gen_clsid generates a new random CLSID and saves it in package.json as
+  bho.clsid so that it stays the same across builds. The CLSID is printed.
+
+  In case the CLSID is set already, it is kept and printed unless -force is
+  set. Changing the CLSID of a released BHO makes the installed copies
+  unregistrable by the new version, so use -force with care.
+		`,
+		Action: runGenClsid,
+	}
+	genClsid.Flags.BoolVar(&bhoForceClsid, "force", bhoForceClsid,
+		"replace the CLSID that is set already")
+	ieExt.MustRegisterSubcommand(genClsid)
+}
+
+func addBhoComFlags(cmd *gocli.Command) {
+	cmd.Flags.StringVar(&bhoComFlags.CLSID, "clsid", "", "class ID of the BHO")
+	cmd.Flags.StringVar(&bhoComFlags.ProgID, "prog_id", "", "ProgID of the BHO")
+	cmd.Flags.StringVar(&bhoComFlags.DLL, "dll", "", "DLL implementing the BHO")
+	cmd.Flags.StringVar(&bhoComFlags.ThreadingModel, "threading_model", "",
+		"threading model of the BHO")
+	cmd.Flags.StringVar(&bhoComFlags.Description, "description", "", "name of the BHO")
+}
+
+// Subcommand flags.
+var (
+	bhoComFlags   bhoRegistration
+	bhoForceClsid bool
+)
+
+// bhoRegistration is the "bho" object of package.json.
+type bhoRegistration struct {
+	CLSID          string `json:"clsid"`
+	ProgID         string `json:"progId"`
+	DLL            string `json:"dll"`
+	ThreadingModel string `json:"threadingModel"`
+	Description    string `json:"description"`
+}
+
+// guidPattern matches a GUID without the braces, a CLSID may be enclosed
+// in braces, but they must be balanced.
+const guidPattern = "[0-9a-fA-F]{8}(-[0-9a-fA-F]{4}){3}-[0-9a-fA-F]{12}"
+
+var (
+	clsidPattern  = regexp.MustCompile("^([{]" + guidPattern + "[}]|" + guidPattern + ")$")
+	progIDPattern = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9_]*([.][a-zA-Z0-9_]+)*$")
+)
+
+var threadingModels = []string{"Apartment", "Both", "Free", "Neutral"}
+
+// Subcommand handler.
+func runGenRgs(cmd *gocli.Command, args []string) {
+	genBhoCom(cmd, args, "bho.rgs", bhoRgsTemplate, nil)
+}
+
+// Subcommand handler.
+func runGenReg(cmd *gocli.Command, args []string) {
+	// regedit expects UTF-16LE with BOM and CRLF line endings.
+	genBhoCom(cmd, args, "bho.reg", bhoRegTemplate, func(content []byte) []byte {
+		text := strings.Replace(string(content), "\n", "\r\n", -1)
+		var buf bytes.Buffer
+		buf.Write([]byte{0xff, 0xfe})
+		for _, c := range utf16.Encode([]rune(text)) {
+			buf.Write([]byte{byte(c), byte(c >> 8)})
+		}
+		return buf.Bytes()
+	})
+}
+
+// genBhoCom renders tmpl using the registration fields and writes the result
+// into the file specified by args, defaultFilename if args are empty.
+// encode, if not nil, is applied to the rendered content.
+func genBhoCom(cmd *gocli.Command, args []string, defaultFilename, tmpl string,
+	encode func([]byte) []byte) {
+
+	if len(args) > 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	filename := defaultFilename
+	if len(args) == 1 {
+		filename = args[0]
+	}
+
+	reg, err := bhoRegistrationFields()
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	t := template.Must(template.New(defaultFilename).Funcs(template.FuncMap{
+		// .rgs strings are single-quoted, a quote is escaped by doubling it.
+		"rgs": func(s string) string {
+			return "'" + strings.Replace(s, "'", "''", -1) + "'"
+		},
+		// .reg strings are double-quoted with backslash escapes.
+		"reg": func(s string) string {
+			s = strings.Replace(s, `\`, `\\`, -1)
+			return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+		},
+	}).Parse(tmpl))
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, reg); err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	content := buf.Bytes()
+	if encode != nil {
+		content = encode(content)
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	if err := file.Close(); err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+}
+
+// bhoRegistrationFields collects the registration fields from package.json
+// and the command line flags, fills in the defaults and checks the values.
+func bhoRegistrationFields() (*bhoRegistration, error) {
+	var reg bhoRegistration
+	if _, err := os.Stat(PackageFile); err == nil {
+		loadPackage()
+		reg = config.Package.BHO
+		if reg.Description == "" {
+			reg.Description = config.Package.Name
+		}
+	}
+
+	for _, f := range []struct{ dst, src *string }{
+		{&reg.CLSID, &bhoComFlags.CLSID},
+		{&reg.ProgID, &bhoComFlags.ProgID},
+		{&reg.DLL, &bhoComFlags.DLL},
+		{&reg.ThreadingModel, &bhoComFlags.ThreadingModel},
+		{&reg.Description, &bhoComFlags.Description},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+
+	if reg.DLL == "" {
+		reg.DLL = "ancho.dll"
+	}
+	if reg.ThreadingModel == "" {
+		reg.ThreadingModel = "Apartment"
+	}
+
+	switch {
+	case reg.CLSID == "":
+		return nil, errors.New("CLSID not set, see ie_ext gen_clsid")
+	case !clsidPattern.MatchString(reg.CLSID):
+		return nil, fmt.Errorf("invalid CLSID: %v", reg.CLSID)
+	case reg.ProgID == "":
+		return nil, errors.New("ProgID not set")
+	case !progIDPattern.MatchString(reg.ProgID) || len(reg.ProgID) > 39:
+		return nil, fmt.Errorf("invalid ProgID: %v", reg.ProgID)
+	case reg.Description == "":
+		return nil, errors.New("description not set")
+	}
+
+	var known bool
+	for _, model := range threadingModels {
+		if strings.EqualFold(reg.ThreadingModel, model) {
+			reg.ThreadingModel = model
+			known = true
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("invalid threading model: %v", reg.ThreadingModel)
+	}
+
+	// Normalise the CLSID to the registry format, {XXXXXXXX-...}.
+	reg.CLSID = "{" + strings.ToUpper(strings.Trim(reg.CLSID, "{}")) + "}"
+	return &reg, nil
+}
+
+// Subcommand handler.
+func runGenClsid(cmd *gocli.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	loadPackage()
+	if clsid := config.Package.BHO.CLSID; clsid != "" && !bhoForceClsid {
+		fmt.Println(clsid)
+		return
+	}
+
+	clsid, err := newCLSID()
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	if config.Dry() {
+		fmt.Printf("Would set bho.clsid to %v in %v\n", clsid, PackageFile)
+		return
+	}
+
+	content, err := ioutil.ReadFile(PackageFile)
+	if err != nil {
+		log.Fatalf("Error: failed to read %v: %v", PackageFile, err)
+	}
+	content, err = setJSONValue(content, []string{"bho", "clsid"}, clsid)
+	if err != nil {
+		log.Fatalf("Error: failed to update %v: %v", PackageFile, err)
+	}
+	if err := ioutil.WriteFile(PackageFile, content, 0644); err != nil {
+		log.Fatalf("Error: failed to write %v: %v", PackageFile, err)
+	}
+
+	fmt.Println(clsid)
+}
+
+// newCLSID returns a random (version 4) GUID in the registry format.
+func newCLSID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("{%X-%X-%X-%X-%X}", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+const bhoRgsTemplate = `HKCR
+{
+	NoRemove CLSID
+	{
+		ForceRemove {{rgs .CLSID}} = s {{rgs .Description}}
+		{
+			InprocServer32 = s '%MODULE%'
+			{
+				val ThreadingModel = s {{rgs .ThreadingModel}}
+			}
+			ProgID = s {{rgs .ProgID}}
+		}
+	}
+	{{rgs .ProgID}} = s {{rgs .Description}}
+	{
+		CLSID = s {{rgs .CLSID}}
+	}
+}
+HKLM
+{
+	NoRemove SOFTWARE
+	{
+		NoRemove Microsoft
+		{
+			NoRemove Windows
+			{
+				NoRemove CurrentVersion
+				{
+					NoRemove Explorer
+					{
+						NoRemove 'Browser Helper Objects'
+						{
+							ForceRemove {{rgs .CLSID}} = s {{rgs .Description}}
+							{
+								val NoExplorer = d '1'
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+`
+
+const bhoRegTemplate = `Windows Registry Editor Version 5.00
+
+[HKEY_CLASSES_ROOT\CLSID\{{.CLSID}}]
+@={{reg .Description}}
+
+[HKEY_CLASSES_ROOT\CLSID\{{.CLSID}}\InprocServer32]
+@={{reg .DLL}}
+"ThreadingModel"={{reg .ThreadingModel}}
+
+[HKEY_CLASSES_ROOT\CLSID\{{.CLSID}}\ProgID]
+@={{reg .ProgID}}
+
+[HKEY_CLASSES_ROOT\{{.ProgID}}]
+@={{reg .Description}}
+
+[HKEY_CLASSES_ROOT\{{.ProgID}}\CLSID]
+@={{reg .CLSID}}
+
+[HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Windows\CurrentVersion\Explorer\Browser Helper Objects\{{.CLSID}}]
+@={{reg .Description}}
+"NoExplorer"=dword:00000001
+`
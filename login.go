@@ -0,0 +1,485 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/httputil"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+const (
+	// Paths of the token endpoints relative to the store URL, used unless
+	// "auth.tokenURL" and "auth.revokeURL" are set for the store.
+	DefaultTokenPath  = "/oauth/token"
+	DefaultRevokePath = "/oauth/revoke"
+
+	// DefaultTokenScope is the scope requested unless -scope is set.
+	DefaultTokenScope = "read write"
+
+	// TokenCacheFilename is the token cache file name within the user
+	// configuration directory, see tokenCachePath.
+	TokenCacheFilename = "tokens.json"
+
+	// Tokens are refreshed when they are about to expire in this interval.
+	tokenExpiryMargin = 30 * time.Second
+)
+
+// AuthConfig is the "auth" object of a store, it specifies the token endpoints.
+// The endpoints follow OAuth 2.0, salsa uses the password grant to get a token,
+// the refresh token grant to refresh it and RFC 7009 to revoke it.
+type AuthConfig struct {
+	TokenURL  string `json:"tokenURL"`
+	RevokeURL string `json:"revokeURL"`
+}
+
+// Subcommand initialisation and registration.
+func init() {
+	login := &gocli.Command{
+		UsageLine: `
+  login [-scope SCOPE]`,
+		Short: "get an access token for the store",
+		Long: `
+  Exchange the username and password for an access token, which is cached in
+  the user configuration directory and used for all the following requests to
+  the store instead of the password. The token is refreshed automatically when
+  it expires, as long as the store issued a refresh token together with it.
+  Otherwise, or when the refresh fails, a new token is requested using
+  the password from the configuration files or the credential helper,
+  if there is one.
+
+  The username and password are taken from the command line, the configuration
+  files or the credential helper, and asked for when not found.
+
+  The store must expose OAuth 2.0 token endpoints, by default these are
+  $storeURL/oauth/token and $storeURL/oauth/revoke, but they can be changed
+  by setting "auth.tokenURL" and "auth.revokeURL" for the store.
+		`,
+		Action: runLogin,
+	}
+	login.Flags.StringVar(&loginScope, "scope", loginScope,
+		"scope of the access token")
+	getApp().MustRegisterSubcommand(login)
+
+	logout := &gocli.Command{
+		UsageLine: `
+  logout`,
+		Short: "revoke the access token for the store",
+		Long: `
+  Revoke the access token obtained by salsa login and remove it from the cache.
+		`,
+		Action: runLogout,
+	}
+	getApp().MustRegisterSubcommand(logout)
+}
+
+var loginScope = DefaultTokenScope
+
+// Subcommand handler.
+func runLogin(cmd *gocli.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	loadConfig()
+	selectStore()
+	store := config.Store()
+
+	// Get the password credentials, ask for them if necessary.
+	cred, err := store.passwordCredentials()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	username, password := cred.Username(), cred.Password()
+	if username == "" {
+		if username, err = readLine("Username: "); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if password == "" {
+		if password, err = readSecretLine("Password: "); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if config.Dry() {
+		fmt.Printf("Would request a token from %v\n", store.TokenURL())
+		return
+	}
+
+	// Request the token.
+	token, err := requestPasswordToken(store, username, password, loginScope)
+	if err != nil {
+		log.Fatalf("Error: login failed: %v", err)
+	}
+	if token.Scope == "" {
+		token.Scope = loginScope
+	}
+
+	if err := saveToken(store, token); err != nil {
+		log.Fatalf("Error: failed to save the token: %v", err)
+	}
+
+	if token.ExpiresAt.IsZero() {
+		fmt.Printf("Logged in to store %v\n", store.Name)
+	} else {
+		fmt.Printf("Logged in to store %v, the token expires at %v\n",
+			store.Name, token.ExpiresAt.Local().Format(time.RFC1123))
+	}
+}
+
+// Subcommand handler.
+func runLogout(cmd *gocli.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	loadConfig()
+	selectStore()
+	store := config.Store()
+
+	token, err := loadToken(store)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if token == nil {
+		fmt.Printf("Not logged in to store %v\n", store.Name)
+		return
+	}
+
+	if config.Dry() {
+		fmt.Printf("Would revoke the token using %v\n", store.RevokeURL())
+		return
+	}
+
+	// Revoke the tokens, but remove them from the cache in any case.
+	if err := revokeToken(store, token); err != nil {
+		log.Printf("Warning: failed to revoke the token: %v", err)
+	}
+
+	if err := saveToken(store, nil); err != nil {
+		log.Fatalf("Error: failed to remove the token: %v", err)
+	}
+
+	fmt.Printf("Logged out of store %v\n", store.Name)
+}
+
+// TokenURL returns the URL of the token endpoint of the store.
+func (store *Store) TokenURL() string {
+	if store.Auth != nil && store.Auth.TokenURL != "" {
+		return store.Auth.TokenURL
+	}
+	return store.URL + DefaultTokenPath
+}
+
+// RevokeURL returns the URL of the token revocation endpoint of the store.
+func (store *Store) RevokeURL() string {
+	if store.Auth != nil && store.Auth.RevokeURL != "" {
+		return store.Auth.RevokeURL
+	}
+	return store.URL + DefaultRevokePath
+}
+
+// Token represents a cached access token.
+type Token struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+}
+
+// Expired returns true when the token is expired or about to expire.
+func (token *Token) Expired() bool {
+	if token.ExpiresAt.IsZero() {
+		return false
+	}
+	return time.Now().Add(tokenExpiryMargin).After(token.ExpiresAt)
+}
+
+// requestToken sends form to the token endpoint of the store.
+func requestToken(store *Store, form url.Values) (*Token, error) {
+	transport, err := store.transport()
+	if err != nil {
+		return nil, err
+	}
+	client := &httputil.Client{Transport: transport}
+
+	tokenURL := store.TokenURL()
+	if config.Verbose() {
+		fmt.Printf("POST %v\n", tokenURL)
+	}
+
+	resp, err := client.PostForm(tokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		RefreshToken     string `json:"refresh_token"`
+		Scope            string `json:"scope"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		if resp.StatusCode >= 300 {
+			return nil, errors.New(resp.Status)
+		}
+		return nil, fmt.Errorf("failed to decode the token response: %v", err)
+	}
+
+	switch {
+	case body.Error != "" && body.ErrorDescription != "":
+		return nil, fmt.Errorf("%v: %v", body.Error, body.ErrorDescription)
+	case body.Error != "":
+		return nil, errors.New(body.Error)
+	case resp.StatusCode >= 300:
+		return nil, errors.New(resp.Status)
+	case body.AccessToken == "":
+		return nil, errors.New("no access token received")
+	case body.TokenType != "" && !strings.EqualFold(body.TokenType, "bearer"):
+		return nil, fmt.Errorf("unsupported token type: %v", body.TokenType)
+	}
+
+	token := &Token{
+		AccessToken:  body.AccessToken,
+		RefreshToken: body.RefreshToken,
+		Scope:        body.Scope,
+	}
+	if body.ExpiresIn > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second).UTC()
+	}
+	return token, nil
+}
+
+// requestPasswordToken exchanges username and password for a token.
+func requestPasswordToken(store *Store, username, password, scope string) (*Token, error) {
+	return requestToken(store, url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+		"scope":      {scope},
+	})
+}
+
+// revokeToken revokes both the access token and the refresh token.
+func revokeToken(store *Store, token *Token) error {
+	transport, err := store.transport()
+	if err != nil {
+		return err
+	}
+	client := &httputil.Client{
+		Credentials: httputil.NewBearerCredentials(staticTokenSource(token.AccessToken)),
+		Transport:   transport,
+	}
+
+	revoke := func(value, hint string) error {
+		resp, err := client.PostForm(store.RevokeURL(), url.Values{
+			"token":           {value},
+			"token_type_hint": {hint},
+		})
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return errors.New(resp.Status)
+		}
+		return nil
+	}
+
+	if token.RefreshToken != "" {
+		if err := revoke(token.RefreshToken, "refresh_token"); err != nil {
+			return err
+		}
+	}
+	return revoke(token.AccessToken, "access_token")
+}
+
+// tokenSource implements httputil.TokenSource for a store, refreshing
+// the token when it expires. A token that cannot be refreshed is replaced
+// using the password grant when the password is configured for the store.
+type tokenSource struct {
+	store *Store
+	token *Token
+	mu    sync.Mutex
+}
+
+func (src *tokenSource) Token() (string, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if !src.token.Expired() {
+		return src.token.AccessToken, nil
+	}
+
+	var (
+		token *Token
+		err   error
+	)
+	if src.token.RefreshToken != "" {
+		token, err = requestToken(src.store, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {src.token.RefreshToken},
+			"scope":         {src.token.Scope},
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to refresh the token for store %v: %v", src.store.Name, err)
+		} else if token.RefreshToken == "" {
+			// Keep using the old refresh token unless a new one is issued.
+			token.RefreshToken = src.token.RefreshToken
+		}
+	}
+
+	// Fall back to the password grant when there is no refresh token
+	// or the refresh failed, e.g. because the refresh token expired.
+	if token == nil {
+		if token, err = src.passwordToken(err); err != nil {
+			return "", err
+		}
+	}
+
+	if token.Scope == "" {
+		token.Scope = src.token.Scope
+	}
+
+	if err := saveToken(src.store, token); err != nil {
+		return "", err
+	}
+	src.token = token
+	return token.AccessToken, nil
+}
+
+// passwordToken requests a new token using the password grant. There is
+// no way to ask for the password here, it must be configured. refreshErr
+// is the refresh failure to report when there is no password, if any.
+func (src *tokenSource) passwordToken(refreshErr error) (*Token, error) {
+	cred, err := src.store.passwordCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if cred.Username() == "" || cred.Password() == "" {
+		if refreshErr != nil {
+			return nil, fmt.Errorf("%v, run salsa login", refreshErr)
+		}
+		return nil, fmt.Errorf("token for store %v expired, run salsa login", src.store.Name)
+	}
+
+	if config.Verbose() {
+		if refreshErr != nil {
+			fmt.Printf("Warning: %v\n", refreshErr)
+		}
+		fmt.Printf("Token for store %v expired, requesting a new one\n", src.store.Name)
+	}
+	token, err := requestPasswordToken(src.store, cred.Username(), cred.Password(), src.token.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a new token for store %v: %v", src.store.Name, err)
+	}
+	return token, nil
+}
+
+type staticTokenSource string
+
+func (src staticTokenSource) Token() (string, error) {
+	return string(src), nil
+}
+
+// The token cache is a JSON object mapping store URLs to tokens.
+var tokenCacheMu sync.Mutex
+
+// tokenCachePath returns the path of the token cache file.
+func tokenCachePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "salsa", TokenCacheFilename), nil
+}
+
+func readTokenCache() (map[string]*Token, error) {
+	path, err := tokenCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]*Token)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tokens, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %v: %v", path, err)
+	}
+	return tokens, nil
+}
+
+// loadToken returns the cached token for store, nil if there is none.
+func loadToken(store *Store) (*Token, error) {
+	tokenCacheMu.Lock()
+	defer tokenCacheMu.Unlock()
+
+	tokens, err := readTokenCache()
+	if err != nil {
+		return nil, err
+	}
+	return tokens[store.URL], nil
+}
+
+// saveToken saves token for store in the cache, nil token removes it.
+func saveToken(store *Store, token *Token) error {
+	tokenCacheMu.Lock()
+	defer tokenCacheMu.Unlock()
+
+	tokens, err := readTokenCache()
+	if err != nil {
+		return err
+	}
+
+	if token != nil {
+		tokens[store.URL] = token
+	} else {
+		delete(tokens, store.URL)
+	}
+
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path, err := tokenCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0600)
+}
@@ -7,14 +7,23 @@ package main
 
 import (
 	// Stdlib
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
-	"regexp"
+	"sync"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/ciutil"
+	"github.com/tchap/salsa/utils/flagutil"
+	"github.com/tchap/salsa/utils/httputil"
+	"github.com/tchap/salsa/utils/semver"
 
 	// Others
 	"github.com/tchap/gocli"
@@ -23,8 +32,6 @@ import (
 const (
 	ConfigFilename = ".salsarc"
 	PackageFile    = "package.json"
-
-	VersionPattern = "^[0-9]+[.][0-9]+[.][0-9]+$"
 )
 
 // Config is encapsulating configuration as collected from various sources,
@@ -33,19 +40,48 @@ type Config struct {
 	Package struct {
 		Name    string
 		Version string
+		BHO     bhoRegistration `json:"bho"`
 	}
 	RC struct {
-		StoreURL string `json:"storeURL"`
-		Secrets  map[string]string
-		Username string
-		Password string
+		StoreURL     string `json:"storeURL"`
+		Secrets      map[string]string
+		Username     string
+		Password     string
+		Stores       StoreMap `json:"stores"`
+		DefaultStore string   `json:"defaultStore"`
+
+		PublishStores []string `json:"publishStores"`
+		PublishPolicy string   `json:"publishPolicy"`
+
+		Encryption *EncryptionConfig `json:"encryption"`
+
+		CredentialHelper string `json:"credentialHelper"`
+
+		TLS   *TLSConfig   `json:"tls"`
+		Proxy *ProxyConfig `json:"proxy"`
+
+		MaxConcurrentTransfers int `json:"maxConcurrentTransfers"`
+
+		BuildNumberFormat string `json:"buildNumberFormat"`
 	}
 	Flags struct {
 		Verbose  bool
 		Dry      bool
 		Username string
 		Password string
+		Store    string
+
+		Trace     bool
+		TraceFile string
+		Quiet     bool
+		LimitRate flagutil.ByteSizeValue
 	}
+
+	// The store selected by bootstrap, see Config.Store.
+	store *Store
+
+	// The package version parsed by bootstrap, see Config.Version.
+	version *semver.Version
 }
 
 func (config *Config) Verbose() bool {
@@ -56,12 +92,65 @@ func (config *Config) Dry() bool {
 	return config.Flags.Dry
 }
 
+// ProgressOutput returns where to report the progress of long-running
+// operations, nil when -quiet is set.
+func (config *Config) ProgressOutput() io.Writer {
+	if config.Flags.Quiet {
+		return nil
+	}
+	return os.Stderr
+}
+
 func (config *Config) Username() string {
-	return config.RC.Username
+	return config.store.Username
 }
 
 func (config *Config) Password() string {
-	return config.RC.Password
+	return config.store.Password
+}
+
+// Limits returns the transfer limits to be shared by all the HTTP clients.
+func (config *Config) Limits() *httputil.Limits {
+	limitsOnce.Do(func() {
+		limits = httputil.NewLimits(config.Flags.LimitRate.N, config.RC.MaxConcurrentTransfers)
+	})
+	return limits
+}
+
+var (
+	limits     *httputil.Limits
+	limitsOnce sync.Once
+)
+
+// Build returns the build being run as detected from the environment.
+func (config *Config) Build() *ciutil.Build {
+	buildOnce.Do(func() {
+		build = ciutil.Detect()
+		if config.Verbose() && build.Provider != "" {
+			fmt.Printf("Detected %v build\n", build.Provider)
+		}
+	})
+	return build
+}
+
+var (
+	build     *ciutil.Build
+	buildOnce sync.Once
+)
+
+// Version returns the package version with the build number attached
+// according to "buildNumberFormat". loadPackage and loadConfig must be
+// called first.
+func (config *Config) Version() (string, error) {
+	return config.version.WithBuildNumber(config.Build().BuildNumber,
+		config.RC.BuildNumberFormat)
+}
+
+// Store returns the artifacts store selected using -store, or the default
+// store in case the flag is not set. selectStore must be called first,
+// publish uses the primary store it publishes to instead.
+func (config *Config) Store() *Store {
+	return config.store
 }
 
 // Global config instance that is used to collect command line flags.
@@ -70,6 +159,17 @@ var config = new(Config)
 
 func bootstrap() {
 	// Part I: Load package.json first.
+	loadPackage()
+
+	// Part II: Update config in cascade from $HOME/.salsarc, then $PWD/.salsarc
+	loadConfig()
+
+	// The stores are selected by the subcommands, the secret is only required
+	// in the stores that are actually used, see publishStores.
+}
+
+// loadPackage reads package.json into config.Package and parses the version.
+func loadPackage() {
 	if config.Verbose() {
 		fmt.Printf("Reading %v ...\n", PackageFile)
 	}
@@ -82,16 +182,22 @@ func bootstrap() {
 		log.Fatalf("Error: failed to unmarshal %v: %v", PackageFile, err)
 	}
 
-	// Part II: Update config in cascade from $HOME/.salsarc, then $PWD/.salsarc
-	user, err := user.Current()
-	if err != nil {
-		log.Fatalf("Error: failed to get the current user: %v", err)
+	if config.Package.Name == "" {
+		log.Fatalln("Error: empty package name")
 	}
 
-	userConfig := os.Getenv("SALSA_USER_CONFIG")
-	if userConfig == "" {
-		userConfig = filepath.Join(user.HomeDir, ConfigFilename)
+	version, err := semver.Parse(config.Package.Version)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
 	}
+	config.version = version
+}
+
+// loadConfig reads the configuration files in cascade into config.RC.
+// package.json is not touched, so this can be used by the subcommands that
+// do not operate on a particular project.
+func loadConfig() {
+	userConfig := userConfigPath()
 
 	// Print warning if the user-specific config file is accessible by other
 	// users. Its mode should be set to 0600 since it can containt credentials.
@@ -124,29 +230,111 @@ func bootstrap() {
 			log.Fatalf("Error: failed to unmarshal %v: %v", configFile, err)
 		}
 	}
+}
 
-	// Verify the config.
-	switch {
-	case config.Package.Name == "":
-		log.Fatalln("Error: empty package name")
-	case config.RC.Secrets[config.Package.Name] == "":
-		log.Fatalf("Error: secret not found for project %v", config.Package.Name)
+// selectStore selects the artifacts store to be returned by config.Store().
+// The passwords and secrets encrypted at rest are decrypted on the way.
+// loadConfig must be called first.
+func selectStore() {
+	if err := decryptConfig(); err != nil {
+		log.Fatalf("Error: failed to decrypt the configuration: %v", err)
 	}
-	match, err := regexp.Match(VersionPattern, []byte(config.Package.Version))
+
+	store, err := config.resolveStore(config.Flags.Store)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Error: %v", err)
+	}
+	config.store = store
+}
+
+// userConfigPath returns the path of the user-specific configuration file.
+func userConfigPath() string {
+	if userConfig := os.Getenv("SALSA_USER_CONFIG"); userConfig != "" {
+		return userConfig
 	}
-	if !match {
-		log.Fatalln("Error: version format mismatch")
+
+	user, err := user.Current()
+	if err != nil {
+		log.Fatalf("Error: failed to get the current user: %v", err)
 	}
+	return filepath.Join(user.HomeDir, ConfigFilename)
+}
 
-	// Set the credentials as expected, that is Flags overwrite all.
-	if config.Flags.Username != "" {
-		config.RC.Username = config.Flags.Username
+// updateUserConfig loads the user-specific configuration file as a generic
+// JSON object, calls update to modify it and writes the result back with the
+// file mode set to 0600. The file is created if it does not exist yet.
+func updateUserConfig(update func(rc map[string]interface{}) error) error {
+	userConfig := userConfigPath()
+
+	rc := make(map[string]interface{})
+	content, err := ioutil.ReadFile(userConfig)
+	switch {
+	case err == nil:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&rc); err != nil {
+			return fmt.Errorf("failed to unmarshal %v: %v", userConfig, err)
+		}
+	case !os.IsNotExist(err):
+		return err
 	}
-	if config.Flags.Password != "" {
-		config.RC.Password = config.Flags.Password
+
+	if err := update(rc); err != nil {
+		return err
 	}
+
+	content, err = json.MarshalIndent(rc, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	// Write a temporary file first and then rename it so that the config file
+	// is never left half-written.
+	tmp, err := ioutil.TempFile(filepath.Dir(userConfig), ConfigFilename)
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), userConfig)
+}
+
+// jsonObject returns the JSON object stored in parent under the given path
+// of keys, creating the missing objects on the way.
+func jsonObject(parent map[string]interface{}, path ...string) (map[string]interface{}, error) {
+	for _, key := range path {
+		switch v := parent[key].(type) {
+		case map[string]interface{}:
+			parent = v
+		case nil:
+			child := make(map[string]interface{})
+			parent[key] = child
+			parent = child
+		default:
+			return nil, fmt.Errorf("config key %v is not an object", key)
+		}
+	}
+	return parent, nil
+}
+
+// Transport returns the HTTP transport to use for the requests not sent to
+// an artifacts store. It uses the top-level "tls" and "proxy" settings.
+// loadConfig must be called first.
+func (config *Config) Transport() (http.RoundTripper, error) {
+	return newTransport(config.RC.TLS, config.RC.Proxy)
 }
 
 // gocli App for parsing of the command line.
@@ -161,7 +349,8 @@ func getApp() *gocli.App {
 	// Otherwise initialise the app and return the new instance.
 	app = gocli.NewApp("salsa")
 	app.UsageLine = `
-  salsa [-h] [-v] [-dry] [-username USER -password PASSWD] SUBCMD`
+  salsa [-h] [-v] [-dry] [-quiet] [-limit-rate RATE] [-trace] [-trace_file FILE]
+        [-store NAME] [-username USER -password PASSWD] SUBCMD`
 	app.Short = "a project build artifacts manager"
 	app.Version = "0.0.1"
 	app.Long = `
@@ -174,17 +363,49 @@ func getApp() *gocli.App {
   If you, however, do not want to specify the credentials on the command line,
   $HOME/.salsarc can be used to set them for you.
 
+  Multiple artifacts stores can be configured in .salsarc under "stores".
+  Every store has its own URL, credentials, project secrets, TLS settings
+  and layout. The store to use is selected using -store, "defaultStore" is
+  used when the flag is not set. See README.md for the details.
+
+  The passwords and secrets in $HOME/.salsarc can be encrypted using
+  salsa config encrypt, they are decrypted transparently afterwards.
+
+  The progress of archiving, uploads and downloads is reported to stderr,
+  either as a status line when running in a terminal, or as a plain line every
+  10 seconds otherwise, or when uploading to multiple stores concurrently.
+  -quiet disables the progress reporting.
+
+  -limit-rate limits the transfer rate of all the uploads and downloads
+  together. The number of transfers running at the same time can be limited
+  using "maxConcurrentTransfers" in .salsarc.
+
+  -trace prints every HTTP request salsa sends, including the response status,
+  headers, timing and the number of bytes transferred. The credentials and
+  project secrets are masked. -trace_file appends the trace to a file instead.
+
 ENVIRONMENTAL VARIABLES:
   SALSA_USER_CONFIG - overwrites the default location for the user-specific
-                      configuration file, which is $HOME/.salsarc`
+                      configuration file, which is $HOME/.salsarc
+  SALSA_PASSPHRASE  - passphrase to decrypt the configuration with`
 	app.Flags.BoolVar(&config.Flags.Verbose, "v", config.Flags.Verbose,
 		"print verbose output")
 	app.Flags.BoolVar(&config.Flags.Dry, "dry", config.Flags.Dry,
 		"just print what would be executed")
-	app.Flags.StringVar(&config.RC.Username, "username", config.RC.Username,
+	app.Flags.BoolVar(&config.Flags.Quiet, "quiet", config.Flags.Quiet,
+		"do not report progress")
+	app.Flags.Var(&config.Flags.LimitRate, "limit-rate",
+		"limit the total transfer rate in bytes per second, e.g. 10M")
+	app.Flags.StringVar(&config.Flags.Store, "store", config.Flags.Store,
+		"artifacts store to use")
+	app.Flags.StringVar(&config.Flags.Username, "username", config.Flags.Username,
 		"Basic auth username")
-	app.Flags.StringVar(&config.RC.Password, "password", "",
+	app.Flags.StringVar(&config.Flags.Password, "password", "",
 		"Basic auth password")
+	app.Flags.BoolVar(&config.Flags.Trace, "trace", config.Flags.Trace,
+		"trace HTTP requests to stderr")
+	app.Flags.StringVar(&config.Flags.TraceFile, "trace_file", config.Flags.TraceFile,
+		"trace HTTP requests to the given file")
 
 	return app
 }
@@ -7,10 +7,15 @@ package main
 
 import (
 	// Stdlib
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 
 	// Salsa
 	"github.com/tchap/salsa/utils/archiver"
@@ -20,18 +25,33 @@ import (
 	"github.com/tchap/gocli"
 )
 
+// Policies deciding whether publishing to multiple stores succeeded.
+const (
+	PublishPolicyAll     = "all"
+	PublishPolicyAny     = "any"
+	PublishPolicyPrimary = "primary"
+)
+
 // Subcommand flags.
 var (
 	publishTag         string
 	publishArchiver    string = "tar.gz"
 	publishKeepArchive bool
+	publishStoreNames  string
+	publishPolicy      string
+	publishVerify      string = VerifyNone
+	publishDeleteBad   bool
+	publishDistTag     string
 )
 
 // Subcommand initialisation and registration.
 func init() {
 	publish := &gocli.Command{
 		UsageLine: `
-  publish [-tag TAG] [-archiver {tar.gz|zip}] [-keep_archive] ARTIFACTS_DIR`,
+  publish [-tag TAG] [-archiver {tar.gz|zip}] [-keep_archive]
+          [-stores NAME,...] [-policy {all|any|primary}]
+          [-verify {none|size|hash}] [-delete_bad] [-dist-tag TAGNAME]
+          ARTIFACTS_DIR`,
 		Short: "publish build artifacts",
 		Long: `
   publish uses ARTIFACTS_DIR as the root directory for the archive that it
@@ -42,8 +62,14 @@ func init() {
     2. read .salsarc in the current working directory (optional),
     3. read the user-specific salsa config file (mandatory),
     4. create the archive from ARTIFACTS_DIR using the selected archiver,
-    5. PUT the archive to $storeURL/$project-$secret/$branch/$archive where
-       archive=$project-$tag-$branch-$version.$archiver
+    5. PUT the archive to $storeURL/$layout where the default layout is
+       $project-$secret/$branch/$filename and
+       filename=$project-$tag-$branch-$version.$archiver
+
+  The archive is uploaded as .$filename.partial-$random first and then moved
+  to its final name using WebDAV MOVE, so it never appears half-written.
+  An existing archive is never overwritten. When the store does not support
+  MOVE, the temporary file is deleted and the archive is uploaded directly.
 
   All the configuration files are JSON files containing relevant keys:
     * package.json is the NPM package.json, salsa uses "name" and "version"
@@ -51,10 +77,45 @@ func init() {
     * the user-specific .salsarc can contain "storeURL" as well as the HTTP
       Basic authentication credentials as "username" and "password".
       Project URL secrets are also store there under "secrets.$project"
+    * both files can define named stores under "stores.$name", see -store,
+      the keys of a store in .salsarc overwrite the user-specific ones
+
+  The archive can be mirrored to multiple stores at once. The stores are
+  listed using -stores or "publishStores" in .salsarc, the first one being
+  the primary store. The uploads run concurrently and the result is decided
+  using -policy, or "publishPolicy" in .salsarc, which is one of
+    * all     - all the uploads must succeed (the default)
+    * any     - at least one upload must succeed
+    * primary - the upload to the primary store must succeed
+
+  Every upload can be verified using -verify, which is one of
+    * none - the upload succeeds when the store returns a 2xx status code
+    * size - HEAD the uploaded archive and compare Content-Length, Digest
+             and ETag, the latter only when it looks like MD5 of the content
+    * hash - download the uploaded archive and compare its SHA-256
+  A failed verification means a failed upload. -delete_bad makes salsa
+  delete the uploaded archive from the store when the verification fails.
+
+  -dist-tag points the given distribution tag to the published version once
+  the archive is uploaded, see salsa tag.
+
+  The version in package.json must be a semantic version, e.g. 1.2.3-rc.1.
+  The build number is attached to the version according to "buildNumberFormat"
+  in .salsarc, which is one of
+    * component  - 1.2.3.N, a fourth component (the default)
+    * prerelease - 1.2.3-N, or 1.2.3-rc.1.N when there is a prerelease already
+    * metadata   - 1.2.3+N
+  A version with a prerelease or metadata cannot get a fourth component,
+  component falls back to prerelease or metadata respectively for it.
+
+  The branch and the build number are detected from the environment of
+  Jenkins, GitLab CI, GitHub Actions, Travis CI, TeamCity, Bamboo and Buildkite.
+  The branch is read from .git/HEAD when not detected otherwise.
 
 ENVIRONMENTAL VARIABLES:
   BRANCH       - if set, $BRANCH is used in the archive filename as $branch
-  BUILD_NUMBER - if set, $version is set to $version.$BUILD_NUMBER
+  BUILD_NUMBER - if set, the build number is attached to $version
+  Both variables take precedence over the detected values.
 		`,
 		Action: runPublish,
 	}
@@ -65,6 +126,16 @@ ENVIRONMENTAL VARIABLES:
 		"archiver to use for packing the artifacts")
 	publish.Flags.BoolVar(&publishKeepArchive, "keep_archive", publishKeepArchive,
 		"do not delete the temporary archive file")
+	publish.Flags.StringVar(&publishStoreNames, "stores", publishStoreNames,
+		"comma-separated list of stores to publish to")
+	publish.Flags.StringVar(&publishPolicy, "policy", publishPolicy,
+		"policy for publishing to multiple stores")
+	publish.Flags.StringVar(&publishVerify, "verify", publishVerify,
+		"verify the uploaded archive")
+	publish.Flags.BoolVar(&publishDeleteBad, "delete_bad", publishDeleteBad,
+		"delete the uploaded archive when the verification fails")
+	publish.Flags.StringVar(&publishDistTag, "dist-tag", publishDistTag,
+		"distribution tag to point to the published version")
 
 	getApp().MustRegisterSubcommand(publish)
 }
@@ -77,16 +148,39 @@ func runPublish(cmd *gocli.Command, args []string) {
 		os.Exit(2)
 	}
 
-	// Load the configuration.
+	// Load the configuration and select the stores to publish to.
 	bootstrap()
+	stores, err := publishStores()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
 
-	// Read the environment.
-	branch := os.Getenv("BRANCH")
-	if branch == "" {
-		branch = "unknown"
+	if publishPolicy == "" {
+		publishPolicy = config.RC.PublishPolicy
+	}
+	switch publishPolicy {
+	case "":
+		publishPolicy = PublishPolicyAll
+	case PublishPolicyAll, PublishPolicyAny, PublishPolicyPrimary:
+	default:
+		log.Fatalf("Error: unknown publish policy: %v", publishPolicy)
 	}
-	if buildNum := os.Getenv("BUILD_NUMBER"); buildNum != "" {
-		config.Package.Version += "." + buildNum
+
+	switch publishVerify {
+	case VerifyNone, VerifySize, VerifyHash:
+	default:
+		log.Fatalf("Error: unknown verification mode: %v", publishVerify)
+	}
+
+	if publishDistTag != "" && !distTagPattern.MatchString(publishDistTag) {
+		log.Fatalf("Error: invalid tag name: %v", publishDistTag)
+	}
+
+	// Read the environment.
+	branch := currentBranch()
+	version, err := config.Version()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
 	// Pack the matching artifacts into an archive.
@@ -114,41 +208,277 @@ func runPublish(cmd *gocli.Command, args []string) {
 	}()
 
 	// Upload the archive.
-	if publishTag != "" {
-		publishTag = "-" + publishTag
-	}
-	filename := fmt.Sprintf(
-		"%v%v-%v-%v.%v",
-		config.Package.Name,
-		publishTag,
-		strings.Replace(branch, "/", "", -1),
-		config.Package.Version,
-		publishArchiver)
-	URL := fmt.Sprintf(
-		"%v/%v-%v/%v/%v",
-		config.RC.StoreURL,
-		config.Package.Name,
-		config.RC.Secrets[config.Package.Name],
-		branch,
-		filename)
+	filename := archiveFilename(config.Package.Name, publishTag, branch,
+		version, publishArchiver)
 
-	if config.Verbose() {
-		fmt.Printf("PUT %v\n", URL)
-	}
 	if config.Dry() {
-		fmt.Printf("Archive uploaded to\n\n  %v\n\n", URL)
+		for _, store := range stores {
+			URL := store.ArtifactURL(config.Package.Name, branch, filename)
+			if config.Verbose() {
+				fmt.Printf("PUT and MOVE %v\n", URL)
+			}
+			fmt.Printf("Archive uploaded to\n\n  %v\n\n", URL)
+			if publishDistTag != "" {
+				fmt.Printf("Tag %v would point to version %v\n",
+					publishDistTag, version)
+			}
+		}
 		return
 	}
 
-	resp, err := httputil.Put(archive, URL, config)
+	// Collect the archive size, and the checksums for the verification.
+	local, err := newLocalFile(archive.Name(), publishVerify != VerifyNone)
 	if err != nil {
-		exitError = fmt.Errorf("Error: failed to upload the archive: %v", err)
+		exitError = fmt.Errorf("Error: %v", err)
 		return
 	}
+
+	// Upload the archive to all the stores concurrently.
+	results := make([]error, len(stores))
+	var wg sync.WaitGroup
+	wg.Add(len(stores))
+	for i, store := range stores {
+		go func(i int, store *Store) {
+			defer wg.Done()
+			results[i] = publishArchive(store, local, branch, version, filename)
+		}(i, store)
+	}
+	wg.Wait()
+
+	// Report the results and apply the policy.
+	var failed int
+	for i, store := range stores {
+		if err := results[i]; err != nil {
+			failed++
+			fmt.Printf("Store %v: failed to upload the archive: %v\n", store.Name, err)
+			continue
+		}
+		fmt.Printf("Store %v: archive uploaded to\n\n  %v\n\n", store.Name,
+			store.ArtifactURL(config.Package.Name, branch, filename))
+	}
+
+	switch publishPolicy {
+	case PublishPolicyAll:
+		if failed != 0 {
+			exitError = fmt.Errorf("Error: failed to upload the archive to %v of %v stores",
+				failed, len(stores))
+		}
+	case PublishPolicyAny:
+		if failed == len(stores) {
+			exitError = errors.New("Error: failed to upload the archive to any store")
+		}
+	case PublishPolicyPrimary:
+		if results[0] != nil {
+			exitError = fmt.Errorf("Error: failed to upload the archive to primary store %v",
+				stores[0].Name)
+		}
+	}
+}
+
+// publishStores returns the stores to publish to, the primary store first.
+// The list of store names is taken from -stores, then "publishStores",
+// and the store selected by -store is used when neither is set. The primary
+// store becomes config.Store(). All the stores must contain the secret.
+func publishStores() ([]*Store, error) {
+	var names []string
+	switch {
+	case publishStoreNames != "":
+		names = strings.Split(publishStoreNames, ",")
+	case len(config.RC.PublishStores) != 0:
+		names = config.RC.PublishStores
+	}
+
+	var stores []*Store
+	if len(names) == 0 {
+		selectStore()
+		stores = append(stores, config.Store())
+	} else {
+		if err := decryptConfig(); err != nil {
+			return nil, fmt.Errorf("failed to decrypt the configuration: %v", err)
+		}
+		for _, name := range names {
+			store, err := config.resolveStore(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			stores = append(stores, store)
+		}
+		config.store = stores[0]
+	}
+
+	for _, store := range stores {
+		if store.Secret(config.Package.Name) == "" {
+			return nil, fmt.Errorf("secret not found for project %v in store %v",
+				config.Package.Name, store.Name)
+		}
+	}
+	return stores, nil
+}
+
+// archiveFilename returns the name of the archive file in the store,
+// which is $project-$tag-$branch-$version.$archiver
+func archiveFilename(project, tag, branch, version, archiver string) string {
+	if tag != "" {
+		tag = "-" + tag
+	}
+	return fmt.Sprintf("%v%v-%v-%v.%v", project, tag,
+		strings.Replace(branch, "/", "", -1), version, archiver)
+}
+
+// currentBranch returns the branch being built, see Config.Build.
+func currentBranch() string {
+	if branch := config.Build().Branch; branch != "" {
+		return branch
+	}
+	return "unknown"
+}
+
+// publishArchive uploads the archive to store and updates the distribution
+// tag afterwards in case -dist-tag is set.
+func publishArchive(store *Store, local *localFile, branch, version, filename string) error {
+	if err := uploadArchive(store, local, branch, filename); err != nil {
+		return err
+	}
+	if publishDistTag == "" {
+		return nil
+	}
+
+	client, err := store.Client()
+	if err != nil {
+		return err
+	}
+	err = updateDistTags(client, store, config.Package.Name, func(tags DistTags) {
+		tags.Set(branch, publishDistTag, &DistTag{
+			Version: version,
+			Commit:  config.Build().Commit,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("archive uploaded, but failed to update tag %v: %v",
+			publishDistTag, err)
+	}
+	return nil
+}
+
+// uploadArchive uploads the archive file to store and verifies the upload.
+//
+// The archive is uploaded under a temporary name first and then moved to the
+// final location using WebDAV MOVE, so that the archive never appears
+// half-written. Direct PUT is used when the store does not support MOVE.
+func uploadArchive(store *Store, local *localFile, branch, filename string) error {
+	client, err := store.Client()
+	if err != nil {
+		return err
+	}
+
+	URL := store.ArtifactURL(config.Package.Name, branch, filename)
+
+	suffix, err := partialSuffix()
+	if err != nil {
+		return err
+	}
+	partialURL := store.ArtifactURL(config.Package.Name, branch,
+		"."+filename+".partial-"+suffix)
+
+	// Upload the archive under the temporary name.
+	if err := putArchive(client, local, partialURL); err != nil {
+		return err
+	}
+
+	// Move the archive to the final location.
+	if config.Verbose() {
+		fmt.Printf("MOVE %v -> %v\n", partialURL, URL)
+	}
+	resp, err := client.Move(partialURL, URL, false)
+	if err != nil {
+		deletePartial(client, partialURL)
+		return err
+	}
+	switch {
+	case httputil.MethodNotSupported(resp):
+		// MOVE not supported, upload the archive again directly.
+		if config.Verbose() {
+			fmt.Println("MOVE not supported, falling back to direct PUT")
+		}
+		deletePartial(client, partialURL)
+		return putArchive(client, local, URL)
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		deletePartial(client, partialURL)
+		return errors.New("the archive already exists")
+	case resp.StatusCode >= 300:
+		deletePartial(client, partialURL)
+		return fmt.Errorf("MOVE: %v", resp.Status)
+	}
+	return nil
+}
+
+// putArchive uploads the archive file to URL and verifies the upload.
+func putArchive(client *httputil.Client, local *localFile, URL string) error {
+	if config.Verbose() {
+		fmt.Printf("PUT %v\n", URL)
+	}
+
+	// Every upload needs its own file descriptor since they run concurrently.
+	archive, err := os.Open(local.path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	resp, err := client.Put(archive, URL)
+	if err != nil {
+		return err
+	}
 	if resp.StatusCode >= 300 {
-		exitError = fmt.Errorf("Error: failed to upload the archive: %v", resp.Status)
-		return
+		return errors.New(resp.Status)
+	}
+
+	if publishVerify == VerifyNone {
+		return nil
+	}
+
+	// Verify the upload, delete the archive if requested and necessary.
+	verifyErr := verifyUpload(client, URL, local, publishVerify)
+	if verifyErr == nil {
+		return nil
 	}
+	if publishDeleteBad {
+		if config.Verbose() {
+			fmt.Printf("DELETE %v\n", URL)
+		}
+		resp, err := client.Delete(URL)
+		switch {
+		case err != nil:
+			return fmt.Errorf("verification failed: %v; failed to delete the archive: %v",
+				verifyErr, err)
+		case resp.StatusCode >= 300:
+			return fmt.Errorf("verification failed: %v; failed to delete the archive: %v",
+				verifyErr, resp.Status)
+		}
+		return fmt.Errorf("verification failed, archive deleted: %v", verifyErr)
+	}
+	return fmt.Errorf("verification failed: %v", verifyErr)
+}
 
-	fmt.Printf("Archive uploaded to\n\n  %v\n\n", URL)
+// partialSuffix returns a random suffix for the temporary archive name.
+func partialSuffix() (string, error) {
+	p := make([]byte, 6)
+	if _, err := rand.Read(p); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(p), nil
+}
+
+// deletePartial deletes the temporary archive, printing a warning on failure.
+func deletePartial(client *httputil.Client, URL string) {
+	if config.Verbose() {
+		fmt.Printf("DELETE %v\n", URL)
+	}
+	resp, err := client.Delete(URL)
+	switch {
+	case err != nil:
+		log.Printf("Warning: failed to delete %v: %v", URL, err)
+	case resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound:
+		log.Printf("Warning: failed to delete %v: %v", URL, resp.Status)
+	}
 }
@@ -0,0 +1,178 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/httputil"
+)
+
+// Upload verification modes, see -verify.
+const (
+	VerifyNone = "none"
+	VerifySize = "size"
+	VerifyHash = "hash"
+)
+
+// localFile describes a local file that is being uploaded.
+// The checksums are only set when the upload is to be verified.
+type localFile struct {
+	path   string
+	size   int64
+	md5    []byte
+	sha256 []byte
+}
+
+// newLocalFile collects the size of the file at path, and its checksums
+// as well when digests is set, which means reading the whole file.
+func newLocalFile(path string, digests bool) (*localFile, error) {
+	if !digests {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return &localFile{path: path, size: info.Size()}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		md5Hash    = md5.New()
+		sha256Hash = sha256.New()
+	)
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localFile{
+		path:   path,
+		size:   size,
+		md5:    md5Hash.Sum(nil),
+		sha256: sha256Hash.Sum(nil),
+	}, nil
+}
+
+// An ETag consisting of 32 hex digits is treated as MD5 of the content,
+// which is what S3 and S3-compatible stores do for simple uploads.
+var md5ETagRegexp = regexp.MustCompile(`^"?([0-9a-fA-F]{32})"?$`)
+
+// verifyUpload checks that the object at URL matches local.
+//
+// In size mode, a HEAD request is sent and Content-Length is compared.
+// Digest and MD5 ETag headers are compared as well if present.
+// In hash mode, the object is downloaded and its SHA-256 compared.
+func verifyUpload(client *httputil.Client, URL string, local *localFile, mode string) error {
+	if mode == VerifyHash {
+		return verifyHash(client, URL, local)
+	}
+
+	if config.Verbose() {
+		fmt.Printf("HEAD %v\n", URL)
+	}
+
+	resp, err := client.Head(URL)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HEAD: %v", resp.Status)
+	}
+
+	// Compare the size.
+	if v := resp.Header.Get("Content-Length"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid Content-Length: %v", v)
+		}
+		if size != local.size {
+			return fmt.Errorf("size mismatch: expected %v bytes, the store has %v bytes",
+				local.size, size)
+		}
+	} else {
+		return fmt.Errorf("the store did not return Content-Length")
+	}
+
+	// Compare Digest, e.g. Digest: SHA-256=base64,MD5=base64
+	for _, digest := range strings.Split(resp.Header.Get("Digest"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(digest), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		var expected []byte
+		switch strings.ToUpper(parts[0]) {
+		case "SHA-256":
+			expected = local.sha256
+		case "MD5":
+			expected = local.md5
+		default:
+			continue
+		}
+
+		if parts[1] != base64.StdEncoding.EncodeToString(expected) {
+			return fmt.Errorf("%v digest mismatch", parts[0])
+		}
+	}
+
+	// Compare ETag in case it looks like MD5.
+	if match := md5ETagRegexp.FindStringSubmatch(resp.Header.Get("ETag")); match != nil {
+		if !strings.EqualFold(match[1], hex.EncodeToString(local.md5)) {
+			return fmt.Errorf("ETag mismatch: expected %x, the store has %v",
+				local.md5, match[1])
+		}
+	}
+
+	return nil
+}
+
+func verifyHash(client *httputil.Client, URL string, local *localFile) error {
+	if config.Verbose() {
+		fmt.Printf("GET %v\n", URL)
+	}
+
+	resp, err := client.Get(URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("GET: %v", resp.Status)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if size != local.size {
+		return fmt.Errorf("size mismatch: expected %v bytes, the store has %v bytes",
+			local.size, size)
+	}
+	if sum := hash.Sum(nil); string(sum) != string(local.sha256) {
+		return fmt.Errorf("SHA-256 mismatch: expected %x, the store has %x",
+			local.sha256, sum)
+	}
+	return nil
+}
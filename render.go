@@ -0,0 +1,246 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/semver"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+// Subcommand flags.
+var renderEnv string
+
+// Subcommand initialisation and registration.
+func init() {
+	render := &gocli.Command{
+		UsageLine: `
+  render [-env NAME,...] TEMPLATE [OUT]`,
+		Short: "render a template using the project metadata",
+		Long: `
+  Render TEMPLATE, which is a Go text/template, and write the result to OUT,
+  or to stdout when OUT is not specified.
+
+  The template context contains the following fields:
+    .Package     - all the fields of package.json, e.g. .Package.description
+    .Name        - the package name
+    .Version     - the version with the build number attached as in publish
+    .Branch      - the branch being built
+    .Commit      - the commit being built
+    .BuildNumber - the build number
+    .PullRequest - the pull request number
+    .Env         - the environment variables selected using -env
+  The fields of package.json are only available when package.json exists
+  in the current working directory. The branch, commit, build number and pull
+  request are detected the same way publish detects them.
+
+  -env is a comma-separated list of the environment variables to make available
+  in .Env, NAME can end with * to select all the variables with the prefix.
+
+  The following functions are available in addition to the standard ones:
+    split SEP S        - split S into a list of strings by SEP
+    join SEP LIST      - join LIST into a string using SEP
+    replace OLD NEW S  - replace all OLD in S with NEW
+    lower S, upper S   - change the case of S
+    versionParts V     - the numeric components of version V,
+                         the prerelease number being the fourth one,
+                         e.g. 1.2.3-4 -> [1 2 3 4]
+    versionCommas V    - versionParts joined by commas, e.g. 1,2,3,4
+    date LAYOUT        - the current date formatted using Go time LAYOUT,
+                         e.g. date "2006-01-02"
+    env NAME           - the environment variable NAME, "" when not set,
+                         NAME must be selected using -env
+
+  A missing field makes the rendering fail. Nothing is written in that case.
+		`,
+		Action: runRender,
+	}
+	render.Flags.StringVar(&renderEnv, "env", renderEnv,
+		"comma-separated environment variables to make available")
+
+	getApp().MustRegisterSubcommand(render)
+}
+
+// renderContext is the context passed to the templates by render.
+type renderContext struct {
+	Package     map[string]interface{}
+	Name        string
+	Version     string
+	Branch      string
+	Commit      string
+	BuildNumber string
+	PullRequest string
+	Env         map[string]string
+
+	// envSelected lists the names passed using -env.
+	envSelected []string
+}
+
+// Subcommand handler.
+func runRender(cmd *gocli.Command, args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	ctx, err := newRenderContext(strings.Split(renderEnv, ","))
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	t, err := template.New(filepath.Base(args[0])).
+		Funcs(renderFuncs).
+		Funcs(template.FuncMap{"env": ctx.env}).
+		Option("missingkey=error").
+		ParseFiles(args[0])
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// Render into a buffer first so that no partial output is written.
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if len(args) == 1 {
+		os.Stdout.Write(buf.Bytes())
+		return
+	}
+
+	if config.Dry() {
+		fmt.Printf("Would write %v\n", args[1])
+		return
+	}
+	if err := ioutil.WriteFile(args[1], buf.Bytes(), 0644); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// newRenderContext collects the render context. env lists the environment
+// variables to include, see render -env.
+func newRenderContext(env []string) (*renderContext, error) {
+	build := config.Build()
+	ctx := &renderContext{
+		Package:     make(map[string]interface{}),
+		Branch:      build.Branch,
+		Commit:      build.Commit,
+		BuildNumber: build.BuildNumber,
+		PullRequest: build.PullRequest,
+		Env:         make(map[string]string),
+	}
+
+	// package.json is optional.
+	if _, err := os.Stat(PackageFile); err == nil {
+		loadPackage()
+		loadConfig()
+
+		content, err := ioutil.ReadFile(PackageFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &ctx.Package); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %v: %v", PackageFile, err)
+		}
+
+		ctx.Name = config.Package.Name
+		if ctx.Version, err = config.Version(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Collect the selected environment variables.
+	for _, name := range env {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			ctx.envSelected = append(ctx.envSelected, name)
+		}
+		switch {
+		case name == "":
+		case strings.HasSuffix(name, "*"):
+			prefix := strings.TrimSuffix(name, "*")
+			for _, kv := range os.Environ() {
+				if i := strings.Index(kv, "="); i != -1 && strings.HasPrefix(kv[:i], prefix) {
+					ctx.Env[kv[:i]] = kv[i+1:]
+				}
+			}
+		default:
+			if v, ok := os.LookupEnv(name); ok {
+				ctx.Env[name] = v
+			}
+		}
+	}
+	return ctx, nil
+}
+
+// env implements the env template function. Only the variables selected
+// using -env can be read so that the templates cannot access the secrets
+// kept in the environment.
+func (ctx *renderContext) env(name string) (string, error) {
+	for _, selected := range ctx.envSelected {
+		if name == selected ||
+			strings.HasSuffix(selected, "*") && strings.HasPrefix(name, strings.TrimSuffix(selected, "*")) {
+
+			return ctx.Env[name], nil
+		}
+	}
+	return "", fmt.Errorf("environment variable %v not selected using -env", name)
+}
+
+var renderFuncs = template.FuncMap{
+	"split": func(sep, s string) []string {
+		return strings.Split(s, sep)
+	},
+	"join": func(sep string, list []string) string {
+		return strings.Join(list, sep)
+	},
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"lower":        strings.ToLower,
+	"upper":        strings.ToUpper,
+	"versionParts": versionParts,
+	"versionCommas": func(version string) (string, error) {
+		parts, err := versionParts(version)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(parts, ","), nil
+	},
+	"date": func(layout string) string {
+		return time.Now().Format(layout)
+	},
+}
+
+// versionParts splits version into its numeric components. The version can be
+// a semantic version with a numeric prerelease, or up to four numbers.
+func versionParts(version string) ([]string, error) {
+	v, err := semver.ParseAny(version)
+	if err != nil {
+		return nil, err
+	}
+	dotted, err := v.Dotted()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(dotted, "."), nil
+}
@@ -0,0 +1,441 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/cryptoutil"
+	"github.com/tchap/salsa/utils/httputil"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+// SecretLength is the number of random bytes used to generate a secret.
+// 15 bytes give 20 characters when encoded.
+const SecretLength = 15
+
+// Subcommand initialisation and registration.
+func init() {
+	secret := &gocli.Command{
+		UsageLine: `
+  secret SUBCMD`,
+		Short: "manage project URL secrets",
+		Long: `
+  Project URL secrets are stored in the user-specific .salsarc under
+  "secrets.$project", or under "stores.$store.secrets.$project" when
+  the secret is specific to a store selected using -store.
+		`,
+	}
+
+	secretNew := &gocli.Command{
+		UsageLine: `
+  new [-force] PROJECT`,
+		Short: "generate a new secret for a project",
+		Long: `
+  Generate a cryptographically random URL-safe secret for PROJECT and save it
+  in the user-specific .salsarc. An existing secret is only overwritten when
+  -force is set, use rotate to change the secret of a published project.
+		`,
+		Action: runSecretNew,
+	}
+	secretNew.Flags.BoolVar(&secretForce, "force", secretForce,
+		"overwrite the existing secret")
+	secret.MustRegisterSubcommand(secretNew)
+
+	secretRotate := &gocli.Command{
+		UsageLine: `
+  rotate [-secret SECRET] PROJECT`,
+		Short: "replace the secret of a project",
+		Long: `
+  Generate a new secret for PROJECT, move all the artifacts published so far
+  from the old project directory to the new one and save the new secret in the
+  user-specific .salsarc.
+
+  The artifacts are moved using WebDAV MOVE requests, the directories are
+  created using MKCOL first. When the store does not support MOVE, every
+  artifact is downloaded, uploaded to the new location and deleted from
+  the old location.
+
+  In case rotate fails in the middle, the new secret is printed and it can be
+  passed to rotate using -secret to finish the job.
+		`,
+		Action: runSecretRotate,
+	}
+	secretRotate.Flags.StringVar(&secretNewSecret, "secret", secretNewSecret,
+		"use SECRET instead of generating a new one")
+	secret.MustRegisterSubcommand(secretRotate)
+
+	secretShow := &gocli.Command{
+		UsageLine: `
+  show [-reveal] PROJECT`,
+		Short: "print the secret of a project",
+		Long: `
+  Print the secret of PROJECT. The secret is masked unless -reveal is set.
+		`,
+		Action: runSecretShow,
+	}
+	secretShow.Flags.BoolVar(&secretReveal, "reveal", secretReveal,
+		"print the secret unmasked")
+	secret.MustRegisterSubcommand(secretShow)
+
+	getApp().MustRegisterSubcommand(secret)
+}
+
+// Subcommand flags.
+var (
+	secretForce     bool
+	secretNewSecret string
+	secretReveal    bool
+)
+
+// Subcommand handler.
+func runSecretNew(cmd *gocli.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	project := args[0]
+
+	loadConfig()
+
+	keys := secretConfigKeys(project)
+	if !secretForce {
+		if store, err := config.resolveStore(config.Flags.Store); err == nil {
+			if store.Secret(project) != "" {
+				log.Fatalf("Error: secret already set for project %v, use -force to overwrite it",
+					project)
+			}
+		}
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		log.Fatalf("Error: failed to generate a secret: %v", err)
+	}
+
+	if config.Dry() {
+		fmt.Printf("Secret for project %v would be saved under %v\n",
+			project, strings.Join(keys, "."))
+		return
+	}
+
+	if err := saveSecret(keys, project, secret); err != nil {
+		log.Fatalf("Error: failed to save the secret: %v", err)
+	}
+
+	fmt.Printf("Secret for project %v saved in %v\n", project, userConfigPath())
+}
+
+// Subcommand handler.
+func runSecretRotate(cmd *gocli.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	project := args[0]
+
+	loadConfig()
+	selectStore()
+	store := config.Store()
+
+	oldSecret := store.Secret(project)
+	if oldSecret == "" {
+		log.Fatalf("Error: secret not found for project %v in store %v", project, store.Name)
+	}
+
+	newSecret := secretNewSecret
+	if newSecret == "" {
+		var err error
+		newSecret, err = generateSecret()
+		if err != nil {
+			log.Fatalf("Error: failed to generate a secret: %v", err)
+		}
+	}
+	if newSecret == oldSecret {
+		log.Fatalln("Error: the new secret is the same as the old one")
+	}
+
+	client, err := store.Client()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// Get the project directories for both secrets.
+	srcURL := store.ProjectURL(project) + "/"
+	store.Secrets[project] = newSecret
+	dstURL := store.ProjectURL(project) + "/"
+
+	// Move the artifacts.
+	if err := moveTree(client, srcURL, dstURL); err != nil {
+		log.Fatalf(`Error: failed to move the artifacts: %v
+
+The new secret is %v, use -secret to resume.`, err, newSecret)
+	}
+
+	if config.Dry() {
+		return
+	}
+
+	// Save the new secret. This is done before deleting the old project
+	// directory so that the secret is not lost when the deletion fails.
+	if err := saveSecret(secretConfigKeys(project), project, newSecret); err != nil {
+		log.Fatalf(`Error: failed to save the secret: %v
+
+The new secret is %v, save it manually.`, err, newSecret)
+	}
+
+	// The old project directory should be empty by now.
+	resp, err := client.Delete(srcURL)
+	if err == nil && resp.StatusCode >= 300 {
+		err = errors.New(resp.Status)
+	}
+	if err != nil {
+		log.Fatalf(`Error: failed to delete %v: %v
+
+The artifacts have been moved and the new secret saved, delete it manually.`, srcURL, err)
+	}
+
+	fmt.Printf("Secret for project %v rotated\n", project)
+}
+
+// Subcommand handler.
+func runSecretShow(cmd *gocli.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	project := args[0]
+
+	loadConfig()
+	selectStore()
+
+	secret := config.Store().Secret(project)
+	if secret == "" {
+		log.Fatalf("Error: secret not found for project %v in store %v",
+			project, config.Store().Name)
+	}
+
+	if secretReveal {
+		fmt.Println(secret)
+	} else {
+		fmt.Println(maskSecret(secret))
+	}
+}
+
+// generateSecret generates a random URL-safe secret.
+func generateSecret() (string, error) {
+	p := make([]byte, SecretLength)
+	if _, err := rand.Read(p); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(p), nil
+}
+
+// maskSecret keeps just the first 4 characters of secret.
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + strings.Repeat("*", len(secret)-4)
+}
+
+// secretConfigKeys returns the path of the object in the user-specific
+// .salsarc where the secret of project is to be saved. The store-specific
+// secrets are used when -store is set or when the selected store already
+// defines its own secret for project.
+func secretConfigKeys(project string) []string {
+	name := config.Flags.Store
+	if name == "" {
+		name = config.RC.DefaultStore
+		if store := config.RC.Stores[name]; store == nil || store.Secrets[project] == "" {
+			name = ""
+		}
+	}
+
+	if name != "" {
+		return []string{"stores", name, "secrets"}
+	}
+	return []string{"secrets"}
+}
+
+// saveSecret saves secret for project in the user-specific .salsarc.
+// The secret is encrypted in case the file is encrypted.
+func saveSecret(keys []string, project, secret string) error {
+	return updateUserConfig(func(rc map[string]interface{}) error {
+		secrets, err := jsonObject(rc, keys...)
+		if err != nil {
+			return err
+		}
+
+		enc, err := rcEncryptionConfig(rc)
+		if err != nil {
+			return err
+		}
+		if enc != nil {
+			key, err := encryptionKey(enc, false)
+			if err != nil {
+				return err
+			}
+			if secret, err = cryptoutil.Encrypt(key, secret); err != nil {
+				return err
+			}
+		}
+
+		secrets[project] = secret
+		return nil
+	})
+}
+
+// moveTree moves all the files from the directory at srcURL to dstURL.
+// The destination directories are created first, since the strict WebDAV
+// servers refuse to create the missing parent collections implicitly.
+func moveTree(client *httputil.Client, srcURL, dstURL string) error {
+	entries, err := client.List(srcURL)
+	if err != nil {
+		return err
+	}
+
+	if err := makeCollection(client, dstURL); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		escaped := (&url.URL{Path: entry}).String()
+		var (
+			src = srcURL + escaped
+			dst = dstURL + escaped
+		)
+
+		if strings.HasSuffix(entry, "/") {
+			if err := moveTree(client, src, dst); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := moveFile(client, src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeCollection creates the directory at URL using WebDAV MKCOL. It is not
+// an error when the directory exists or the store does not support MKCOL.
+func makeCollection(client *httputil.Client, URL string) error {
+	if config.Verbose() || config.Dry() {
+		fmt.Printf("MKCOL %v\n", URL)
+	}
+	if config.Dry() {
+		return nil
+	}
+
+	resp, err := client.Mkcol(URL)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 && !httputil.MethodNotSupported(resp) {
+		return fmt.Errorf("MKCOL %v: %v", URL, resp.Status)
+	}
+	return nil
+}
+
+// moveFile moves srcURL to dstURL, falling back to GET, PUT and DELETE
+// in case the store does not support WebDAV MOVE.
+func moveFile(client *httputil.Client, srcURL, dstURL string) error {
+	if config.Verbose() || config.Dry() {
+		fmt.Printf("MOVE %v -> %v\n", srcURL, dstURL)
+	}
+	if config.Dry() {
+		return nil
+	}
+
+	resp, err := client.Move(srcURL, dstURL, false)
+	if err != nil {
+		return err
+	}
+	if !httputil.MethodNotSupported(resp) {
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("MOVE %v: %v", srcURL, resp.Status)
+		}
+		return nil
+	}
+
+	// MOVE not supported, copy the file and delete the original.
+	if config.Verbose() {
+		fmt.Println("MOVE not supported, falling back to GET, PUT and DELETE")
+	}
+
+	// Download the file first, the GET holds a transfer slot until its body
+	// is closed and the PUT would wait for another one otherwise.
+	tmp, err := downloadTemp(client, srcURL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	putResp, err := client.Put(tmp, dstURL)
+	if err != nil {
+		return err
+	}
+	if putResp.StatusCode >= 300 {
+		return fmt.Errorf("PUT %v: %v", dstURL, putResp.Status)
+	}
+
+	delResp, err := client.Delete(srcURL)
+	if err != nil {
+		return err
+	}
+	if delResp.StatusCode >= 300 {
+		return fmt.Errorf("DELETE %v: %v", srcURL, delResp.Status)
+	}
+	return nil
+}
+
+// downloadTemp downloads URL into a temporary file, which is returned open
+// and set to offset 0. The caller is responsible for removing the file.
+func downloadTemp(client *httputil.Client, URL string) (*os.File, error) {
+	resp, err := client.Get(URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("GET %v: %v", URL, resp.Status)
+	}
+
+	tmp, err := ioutil.TempFile("", "salsa_move_")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, os.SEEK_SET); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
@@ -0,0 +1,336 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/credhelper"
+	"github.com/tchap/salsa/utils/httputil"
+)
+
+// DefaultStoreLayout is the layout used for stores that do not specify any.
+// The first path segment of a layout is the project directory.
+const DefaultStoreLayout = "$project-$secret/$branch/$filename"
+
+// DefaultStoreName is the name of the store assembled from the top-level
+// .salsarc keys, that is "storeURL", "username", "password" and "secrets".
+const DefaultStoreName = "default"
+
+// Store represents an artifacts store as configured in .salsarc under
+// "stores.$name".
+type Store struct {
+	Name     string            `json:"-"`
+	URL      string            `json:"url"`
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Secrets  map[string]string `json:"secrets"`
+	Layout   string            `json:"layout"`
+	TLS      *TLSConfig        `json:"tls"`
+	Proxy    *ProxyConfig      `json:"proxy"`
+	Auth     *AuthConfig       `json:"auth"`
+
+	CredentialHelper string `json:"credentialHelper"`
+
+	// The transport is shared by all the clients of the store,
+	// so that the connections can be reused, see transport.
+	transportOnce sync.Once
+	rt            http.RoundTripper
+	rtErr         error
+}
+
+// StoreMap maps the store names to the stores, it is "stores" in .salsarc.
+//
+// When unmarshalled into a map that is not empty, the stores are merged field
+// by field, so that $PWD/.salsarc can overwrite just some of the keys of a store
+// defined in $HOME/.salsarc instead of replacing the whole store.
+type StoreMap map[string]*Store
+
+func (stores *StoreMap) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if *stores == nil {
+		*stores = make(StoreMap, len(raw))
+	}
+	for name, value := range raw {
+		store := (*stores)[name]
+		if store == nil {
+			store = new(Store)
+		}
+		if err := json.Unmarshal(value, store); err != nil {
+			return fmt.Errorf("store %v: %v", name, err)
+		}
+		(*stores)[name] = store
+	}
+	return nil
+}
+
+// ProxyConfig is the proxy configuration, see httputil.ProxyConfig.
+type ProxyConfig httputil.ProxyConfig
+
+// TLSConfig is the TLS client configuration.
+type TLSConfig struct {
+	CAFile             string `json:"caFile"`
+	CertFile           string `json:"certFile"`
+	KeyFile            string `json:"keyFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// resolveStore returns the store called name. The top-level .salsarc keys are
+// used as the defaults for all the stores, the store-specific keys overwrite
+// them and the command line flags overwrite all. In case name is empty,
+// "defaultStore" is used, and if that is not set either, the store assembled
+// just from the top-level keys is returned.
+func (config *Config) resolveStore(name string) (*Store, error) {
+	store := &Store{
+		Name:     DefaultStoreName,
+		URL:      config.RC.StoreURL,
+		Username: config.RC.Username,
+		Password: config.RC.Password,
+		Secrets:  make(map[string]string, len(config.RC.Secrets)),
+		Layout:   DefaultStoreLayout,
+		TLS:      config.RC.TLS,
+		Proxy:    config.RC.Proxy,
+
+		CredentialHelper: config.RC.CredentialHelper,
+	}
+	for project, secret := range config.RC.Secrets {
+		store.Secrets[project] = secret
+	}
+
+	if name == "" {
+		name = config.RC.DefaultStore
+	}
+	if name != "" {
+		named, ok := config.RC.Stores[name]
+		if !ok || named == nil {
+			return nil, fmt.Errorf("store not found: %v", name)
+		}
+
+		store.Name = name
+		if named.URL != "" {
+			store.URL = named.URL
+		}
+		if named.Username != "" {
+			store.Username = named.Username
+		}
+		if named.Password != "" {
+			store.Password = named.Password
+		}
+		for project, secret := range named.Secrets {
+			store.Secrets[project] = secret
+		}
+		if named.Layout != "" {
+			store.Layout = named.Layout
+		}
+		if named.TLS != nil {
+			store.TLS = named.TLS
+		}
+		if named.Proxy != nil {
+			store.Proxy = named.Proxy
+		}
+		store.Auth = named.Auth
+		if named.CredentialHelper != "" {
+			store.CredentialHelper = named.CredentialHelper
+		}
+	}
+
+	if config.Flags.Username != "" {
+		store.Username = config.Flags.Username
+	}
+	if config.Flags.Password != "" {
+		store.Password = config.Flags.Password
+	}
+
+	if store.URL == "" {
+		return nil, fmt.Errorf("URL not set for store %v", store.Name)
+	}
+	store.URL = strings.TrimRight(store.URL, "/")
+
+	return store, nil
+}
+
+// Secret returns the URL secret for project, an empty string if not set.
+func (store *Store) Secret(project string) string {
+	return store.Secrets[project]
+}
+
+// ArtifactURL returns the URL of filename as published for project and branch.
+func (store *Store) ArtifactURL(project, branch, filename string) string {
+	return store.URL + "/" + store.expand(store.Layout, project, branch, filename)
+}
+
+// ProjectURL returns the URL of the project directory, which is the first path
+// segment of the store layout.
+func (store *Store) ProjectURL(project string) string {
+	dir := store.Layout
+	if i := strings.Index(dir, "/"); i != -1 {
+		dir = dir[:i]
+	}
+	return store.URL + "/" + store.expand(dir, project, "", "")
+}
+
+func (store *Store) expand(layout, project, branch, filename string) string {
+	return os.Expand(layout, func(key string) string {
+		switch key {
+		case "project":
+			return project
+		case "secret":
+			return store.Secret(project)
+		case "branch":
+			return branch
+		case "filename":
+			return filename
+		}
+		return ""
+	})
+}
+
+// Client returns a HTTP client set up to talk to the store.
+func (store *Store) Client() (*httputil.Client, error) {
+	transport, err := store.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := store.credentials()
+	if err != nil {
+		return nil, fmt.Errorf("store %v: %v", store.Name, err)
+	}
+
+	return &httputil.Client{
+		Credentials:    cred,
+		Transport:      transport,
+		ProgressOutput: config.ProgressOutput(),
+		Limits:         config.Limits(),
+	}, nil
+}
+
+// transport returns the HTTP transport to use for the store. It is created
+// on the first call, the following calls return the same transport.
+func (store *Store) transport() (http.RoundTripper, error) {
+	store.transportOnce.Do(func() {
+		store.rt, store.rtErr = newTransport(store.TLS, store.Proxy)
+		if store.rtErr != nil {
+			store.rtErr = fmt.Errorf("store %v: %v", store.Name, store.rtErr)
+		}
+	})
+	return store.rt, store.rtErr
+}
+
+// credentials returns the credentials to use for the store. The token saved
+// by salsa login is used unless the credentials are set on the command line.
+func (store *Store) credentials() (httputil.Credentials, error) {
+	if config.Flags.Username == "" {
+		token, err := loadToken(store)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			return httputil.NewBearerCredentials(&tokenSource{store: store, token: token}), nil
+		}
+	}
+
+	return store.passwordCredentials()
+}
+
+// passwordCredentials returns the credentials to use for Basic authentication.
+// The credentials set in the configuration files or on the command line take
+// precedence, the credential helper is only asked when they are not set.
+// The credentials not coming from the helper are stored using the helper
+// once the store accepts them.
+func (store *Store) passwordCredentials() (httputil.Credentials, error) {
+	if store.CredentialHelper == "" {
+		return httputil.NewCredentials(store.Username, store.Password), nil
+	}
+
+	helper, err := credhelper.New(store.CredentialHelper)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := store.Host()
+	if err != nil {
+		return nil, err
+	}
+
+	if store.Username != "" && store.Password != "" {
+		return credhelper.Wrap(helper, host, store.Username, store.Password), nil
+	}
+
+	cred, err := credhelper.Lookup(helper, host)
+	switch {
+	case err == credhelper.ErrNotFound:
+		if config.Verbose() {
+			fmt.Printf("No credentials found for %v using %v\n", host, store.CredentialHelper)
+		}
+		return httputil.NewCredentials(store.Username, store.Password), nil
+	case err != nil:
+		return nil, err
+	}
+	return cred, nil
+}
+
+// Host returns the host part of the store URL, which is used as the key
+// for credential lookup.
+func (store *Store) Host() (string, error) {
+	u, err := url.Parse(store.URL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid store URL: %v", store.URL)
+	}
+	return u.Host, nil
+}
+
+// load assembles tls.Config by loading the files referenced by TLSConfig.
+func (cfg *TLSConfig) load() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	switch {
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case cfg.CertFile != "" || cfg.KeyFile != "":
+		return nil, errors.New("both certFile and keyFile must be set")
+	}
+
+	return tlsConfig, nil
+}
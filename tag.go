@@ -0,0 +1,383 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/httputil"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+// DistTagsFilename is the name of the document in the project directory
+// that contains the distribution tags of the project.
+const DistTagsFilename = "dist-tags.json"
+
+// Distribution tag names must start with a letter so that they cannot be
+// mistaken for a version, see resolveVersion.
+var distTagPattern = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9._-]*$")
+
+// DistTag points to a published version of a project.
+type DistTag struct {
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DistTags is the content of the distribution tags document,
+// it maps branch names to tag names to the tags themselves.
+type DistTags map[string]map[string]*DistTag
+
+// Subcommand initialisation and registration.
+func init() {
+	tag := &gocli.Command{
+		UsageLine: `
+  tag SUBCMD`,
+		Short: "manage distribution tags",
+		Long: `
+  Distribution tags are names like latest or stable that point to a particular
+  version of a project published from a particular branch, so that consumers
+  can fetch the newest good build without listing the store.
+
+  The tags are kept in $project-$secret/dist-tags.json in the store, or in the
+  project directory as specified by the store layout in general.
+
+  Every command that takes a VERSION accepts a tag name in its place.
+  Tag names must start with a letter, versions start with a digit.
+		`,
+	}
+
+	tagSet := &gocli.Command{
+		UsageLine: `
+  set [-branch BRANCH] [-tag TAG] [-archiver {tar.gz|zip}]
+      PROJECT VERSION TAGNAME`,
+		Short: "point a distribution tag to a version",
+		Long: `
+  Point TAGNAME to VERSION of PROJECT published from BRANCH. The branch is
+  detected the same way publish detects it unless -branch is set.
+
+  The archive of VERSION must exist in the store. The archive file name is
+  computed the same way publish computes it, so -tag and -archiver must match
+  the ones used to publish the version.
+		`,
+		Action: runTagSet,
+	}
+	tagSet.Flags.StringVar(&tagBranch, "branch", tagBranch,
+		"branch the version was published from")
+	tagSet.Flags.StringVar(&tagArchiveTag, "tag", tagArchiveTag,
+		"tag used in the archive file name")
+	tagSet.Flags.StringVar(&tagArchiver, "archiver", tagArchiver,
+		"archiver used to pack the artifacts")
+	tag.MustRegisterSubcommand(tagSet)
+
+	tagLs := &gocli.Command{
+		UsageLine: `
+  ls [-branch BRANCH] PROJECT`,
+		Short: "list distribution tags",
+		Long: `
+  List the distribution tags of PROJECT, for all the branches unless -branch
+  is set.
+		`,
+		Action: runTagLs,
+	}
+	tagLs.Flags.StringVar(&tagBranch, "branch", tagBranch,
+		"list the tags for BRANCH only")
+	tag.MustRegisterSubcommand(tagLs)
+
+	getApp().MustRegisterSubcommand(tag)
+}
+
+// Subcommand flags.
+var (
+	tagBranch     string
+	tagArchiveTag string
+	tagArchiver   = "tar.gz"
+)
+
+// distTagsRetries is the number of attempts to update the distribution tags
+// document when it is being modified concurrently.
+const distTagsRetries = 5
+
+// Subcommand handler.
+func runTagSet(cmd *gocli.Command, args []string) {
+	if len(args) != 3 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	project, version, name := args[0], args[1], args[2]
+
+	if !distTagPattern.MatchString(name) {
+		log.Fatalf("Error: invalid tag name: %v", name)
+	}
+
+	branch := tagBranch
+	if branch == "" {
+		branch = currentBranch()
+	}
+
+	store, client := tagStore(project)
+
+	// Resolve the version in case another tag is being used.
+	version, err := resolveVersion(client, store, project, branch, version)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// Make sure the version has actually been published.
+	if err := checkPublished(client, store, project, branch, version); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if config.Dry() {
+		fmt.Printf("Would point tag %v to version %v of project %v on branch %v\n",
+			name, version, project, branch)
+		return
+	}
+
+	err = updateDistTags(client, store, project, func(tags DistTags) {
+		tags.Set(branch, name, &DistTag{Version: version})
+	})
+	if err != nil {
+		log.Fatalf("Error: failed to set tag %v: %v", name, err)
+	}
+
+	fmt.Printf("Tag %v of project %v on branch %v points to version %v\n",
+		name, project, branch, version)
+}
+
+// Subcommand handler.
+func runTagLs(cmd *gocli.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	project := args[0]
+
+	store, client := tagStore(project)
+
+	tags, err := loadDistTags(client, store, project)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	var branches []string
+	for branch := range tags {
+		if tagBranch == "" || branch == tagBranch {
+			branches = append(branches, branch)
+		}
+	}
+	sort.Strings(branches)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, branch := range branches {
+		var names []string
+		for name := range tags[branch] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			tag := tags[branch][name]
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", branch, name, tag.Version,
+				tag.UpdatedAt.Local().Format(time.RFC3339))
+		}
+	}
+	tw.Flush()
+}
+
+// tagStore selects the store and returns it together with its client.
+func tagStore(project string) (*Store, *httputil.Client) {
+	loadConfig()
+	selectStore()
+	store := config.Store()
+
+	if store.Secret(project) == "" {
+		log.Fatalf("Error: secret not found for project %v in store %v", project, store.Name)
+	}
+
+	client, err := store.Client()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	return store, client
+}
+
+// Set sets the tag called name for branch.
+func (tags DistTags) Set(branch, name string, tag *DistTag) {
+	if tags[branch] == nil {
+		tags[branch] = make(map[string]*DistTag)
+	}
+	tag.UpdatedAt = time.Now().UTC()
+	tags[branch][name] = tag
+}
+
+// Get returns the tag called name for branch, nil if not found.
+func (tags DistTags) Get(branch, name string) *DistTag {
+	return tags[branch][name]
+}
+
+// distTagsURL returns the URL of the distribution tags document of project.
+func distTagsURL(store *Store, project string) string {
+	return store.ProjectURL(project) + "/" + DistTagsFilename
+}
+
+// loadDistTags downloads the distribution tags document of project.
+// An empty set of tags is returned in case the document does not exist.
+func loadDistTags(client *httputil.Client, store *Store, project string) (DistTags, error) {
+	tags, _, err := fetchDistTags(client, store, project)
+	return tags, err
+}
+
+// fetchDistTags is the same as loadDistTags, but it returns the header to be
+// used to upload the modified document conditionally, so that no concurrent
+// modification is overwritten. That is If-Match with the document ETag or
+// If-None-Match: * in case the document does not exist yet.
+func fetchDistTags(client *httputil.Client, store *Store, project string) (DistTags, http.Header, error) {
+	URL := distTagsURL(store, project)
+	if config.Verbose() {
+		fmt.Printf("GET %v\n", URL)
+	}
+
+	// The document is small, do not report the progress.
+	quiet := *client
+	quiet.ProgressOutput = nil
+
+	resp, err := quiet.Get(URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	tags := make(DistTags)
+	header := make(http.Header)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		header.Set("If-None-Match", "*")
+		return tags, header, nil
+	case resp.StatusCode >= 300:
+		return nil, nil, fmt.Errorf("GET %v: %v", DistTagsFilename, resp.Status)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		header.Set("If-Match", etag)
+	} else if config.Verbose() {
+		fmt.Printf("Warning: no ETag for %v, concurrent updates cannot be detected\n",
+			DistTagsFilename)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %v: %v", DistTagsFilename, err)
+	}
+	return tags, header, nil
+}
+
+// updateDistTags downloads the distribution tags document of project,
+// calls update to modify the tags and uploads the document back.
+// The document is uploaded conditionally, in case it has been modified
+// in the meantime, the whole process is repeated.
+func updateDistTags(client *httputil.Client, store *Store, project string, update func(DistTags)) error {
+	URL := distTagsURL(store, project)
+
+	quiet := *client
+	quiet.ProgressOutput = nil
+
+	for attempt := 1; ; attempt++ {
+		tags, header, err := fetchDistTags(client, store, project)
+		if err != nil {
+			return err
+		}
+
+		update(tags)
+
+		content, err := json.MarshalIndent(tags, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if config.Verbose() {
+			fmt.Printf("PUT %v\n", URL)
+		}
+
+		resp, err := quiet.PutWithHeader(bytes.NewReader(content), URL, header)
+		if err != nil {
+			return err
+		}
+		switch {
+		case resp.StatusCode == http.StatusPreconditionFailed && attempt < distTagsRetries:
+			if config.Verbose() {
+				fmt.Printf("%v modified concurrently, retrying\n", DistTagsFilename)
+			}
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+			continue
+		case resp.StatusCode == http.StatusPreconditionFailed:
+			return fmt.Errorf("PUT %v: modified concurrently %v times in a row",
+				DistTagsFilename, distTagsRetries)
+		case resp.StatusCode >= 300:
+			return fmt.Errorf("PUT %v: %v", DistTagsFilename, resp.Status)
+		}
+		return nil
+	}
+}
+
+// checkPublished checks whether the archive of version of project published
+// from branch exists in store, see tag set.
+func checkPublished(client *httputil.Client, store *Store, project, branch, version string) error {
+	filename := archiveFilename(project, tagArchiveTag, branch, version, tagArchiver)
+	URL := store.ArtifactURL(project, branch, filename)
+	if config.Verbose() {
+		fmt.Printf("HEAD %v\n", URL)
+	}
+
+	resp, err := client.Head(URL)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("version %v of project %v not found on branch %v: %v does not exist",
+			version, project, branch, filename)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("HEAD %v: %v", filename, resp.Status)
+	}
+	return nil
+}
+
+// resolveVersion returns version unchanged when it starts with a digit,
+// otherwise it is treated as a distribution tag name and the version
+// the tag points to is returned.
+func resolveVersion(client *httputil.Client, store *Store, project, branch, version string) (string, error) {
+	if version == "" {
+		return "", errors.New("empty version")
+	}
+	if c := version[0]; c >= '0' && c <= '9' {
+		return version, nil
+	}
+
+	tags, err := loadDistTags(client, store, project)
+	if err != nil {
+		return "", err
+	}
+	tag := tags.Get(branch, version)
+	if tag == nil {
+		return "", fmt.Errorf("tag %v not found for project %v on branch %v",
+			version, project, branch)
+	}
+	return tag.Version, nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/httputil"
+)
+
+// newTransport returns a HTTP transport using the given TLS and proxy
+// configuration, any of which can be nil. The transport is wrapped
+// according to the global command line flags, see wrapTransport.
+func newTransport(tlsCfg *TLSConfig, proxyCfg *ProxyConfig) (http.RoundTripper, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+
+	if tlsCfg != nil {
+		tlsConfig, err := tlsCfg.load()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if proxyCfg != nil {
+		transport.Proxy = (*httputil.ProxyConfig)(proxyCfg).ProxyFunc()
+	}
+
+	return wrapTransport(transport), nil
+}
+
+// wrapTransport wraps rt with the round trippers enabled on the command line.
+func wrapTransport(rt http.RoundTripper) http.RoundTripper {
+	if config.Flags.Trace || config.Flags.TraceFile != "" {
+		rt = &httputil.TraceTransport{
+			Transport: rt,
+			Output:    traceOutput(),
+			Redact:    redactSecrets,
+		}
+	}
+	return rt
+}
+
+var (
+	traceWriter     io.Writer
+	traceWriterOnce sync.Once
+)
+
+// traceOutput returns the writer to write the HTTP trace to, which is either
+// stderr or the file specified using -trace_file. The file is appended to.
+func traceOutput() io.Writer {
+	traceWriterOnce.Do(func() {
+		if config.Flags.TraceFile == "" {
+			traceWriter = os.Stderr
+			return
+		}
+
+		file, err := os.OpenFile(config.Flags.TraceFile,
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatalf("Error: failed to open the trace file: %v", err)
+		}
+		traceWriter = file
+	})
+	return traceWriter
+}
+
+// redactSecrets replaces all the project secrets known in s with asterisks.
+func redactSecrets(s string) string {
+	redact := func(secrets map[string]string) {
+		for _, secret := range secrets {
+			if secret != "" {
+				s = strings.Replace(s, secret, "***", -1)
+			}
+		}
+	}
+
+	redact(config.RC.Secrets)
+	for _, store := range config.RC.Stores {
+		if store != nil {
+			redact(store.Secrets)
+		}
+	}
+	if config.store != nil {
+		redact(config.store.Secrets)
+	}
+	return s
+}
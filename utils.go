@@ -6,20 +6,268 @@
 package main
 
 import (
+	// Stdlib
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
 	"strings"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/semver"
 )
 
-func toPackageJsonVersion(ver *string) {
-	switch strings.Count(*ver, ".") {
-	case 0:
-		*ver = *ver + ".0.0"
-	case 1:
-		*ver = *ver + ".0"
-	case 2:
-	case 3:
-		i := strings.LastIndex(*ver, ".")
-		*ver = (*ver)[:i] + "-" + (*ver)[i+1:]
+// toPackageJsonVersion converts a Chrome extension version, which consists
+// of one to four numbers, into a semantic version, e.g. 1.2.3.4 -> 1.2.3-4
+func toPackageJsonVersion(ver string) (string, error) {
+	v, err := semver.ParseDotted(ver)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+// fromPackageJsonVersion is the inverse of toPackageJsonVersion.
+func fromPackageJsonVersion(ver string) (string, error) {
+	v, err := semver.Parse(ver)
+	if err != nil {
+		return "", err
+	}
+	return v.Dotted()
+}
+
+// setJSONString replaces the string value of the top-level key in the JSON
+// object contained in content. The rest of content is left untouched so that
+// the key order and the formatting are preserved.
+func setJSONString(content []byte, key, value string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('{') {
+		return nil, errors.New("JSON object expected")
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keyEnd := decoder.InputOffset()
+
+		if token != key {
+			// Skip the value.
+			var v json.RawMessage
+			if err := decoder.Decode(&v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := token.(string); !ok {
+			return nil, fmt.Errorf("key %q is not a string", key)
+		}
+		valueEnd := int(decoder.InputOffset())
+
+		// Find the opening quote of the value, there is just the colon and
+		// white space between the key and the value.
+		start := int(keyEnd) + bytes.IndexByte(content[keyEnd:valueEnd], '"')
+
+		quoted, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		var out []byte
+		out = append(out, content[:start]...)
+		out = append(out, quoted...)
+		out = append(out, content[valueEnd:]...)
+		return out, nil
+	}
+	return nil, fmt.Errorf("key %q not found", key)
+}
+
+// setJSONValue sets the value at path in the JSON object contained in content,
+// e.g. path bho, clsid sets "clsid" in the "bho" object. The missing keys are
+// inserted, the existing value is replaced. Like with setJSONString, the rest
+// of content is left untouched.
+func setJSONValue(content []byte, path []string, value interface{}) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, errors.New("empty path")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+
+	for depth := range path {
+		if token, err := decoder.Token(); err != nil {
+			return nil, err
+		} else if token != json.Delim('{') {
+			return nil, fmt.Errorf("%v: JSON object expected", strings.Join(path[:depth], "."))
+		}
+		objectStart := int(decoder.InputOffset()) - 1
+
+		var (
+			found      bool
+			lastKey    = -1
+			lastValEnd int
+		)
+		for decoder.More() {
+			keyStart := int(decoder.InputOffset())
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			lastKey = keyStart + bytes.IndexByte(content[keyStart:], '"')
+
+			if token == path[depth] {
+				found = true
+				break
+			}
+
+			var v json.RawMessage
+			if err := decoder.Decode(&v); err != nil {
+				return nil, err
+			}
+			lastValEnd = int(decoder.InputOffset())
+		}
+
+		if !found {
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			objectEnd := int(decoder.InputOffset()) - 1
+			return insertJSONMember(content, objectStart, objectEnd, lastKey, lastValEnd,
+				path[depth:], value)
+		}
+
+		if depth == len(path)-1 {
+			// Replace the value.
+			valueStart := int(decoder.InputOffset())
+			var v json.RawMessage
+			if err := decoder.Decode(&v); err != nil {
+				return nil, err
+			}
+			valueEnd := int(decoder.InputOffset())
+			valueStart += bytes.Index(content[valueStart:valueEnd], v)
+
+			marshalled, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+
+			var out []byte
+			out = append(out, content[:valueStart]...)
+			out = append(out, marshalled...)
+			out = append(out, content[valueEnd:]...)
+			return out, nil
+		}
+	}
+	panic("unreachable")
+}
+
+// insertJSONMember inserts path: value into the object spanning from
+// objectStart to objectEnd, the offsets of its braces. lastKey and lastValEnd
+// are the offsets of the last member, lastKey is -1 for an empty object.
+// The indentation of the last member is used for the new one.
+func insertJSONMember(content []byte, objectStart, objectEnd, lastKey, lastValEnd int,
+	path []string, value interface{}) ([]byte, error) {
+
+	// Nest value into the objects that are missing.
+	for i := len(path) - 1; i > 0; i-- {
+		value = map[string]interface{}{path[i]: value}
+	}
+
+	// Find out whether the members are placed on separate lines.
+	objectIndent := lineIndent(content, objectStart)
+	var indent, sep string
+	switch {
+	case lastKey == -1:
+		indent = objectIndent + "  "
+	case strings.TrimSpace(string(content[lineStart(content, lastKey):lastKey])) == "":
+		indent = lineIndent(content, lastKey)
+		sep = ",\n" + indent
 	default:
-		panic("invalid version string: " + *ver)
+		sep = ", "
+	}
+
+	var (
+		key, marshalled []byte
+		err             error
+	)
+	if key, err = json.Marshal(path[0]); err != nil {
+		return nil, err
+	}
+	if indent != "" {
+		unit := strings.TrimPrefix(indent, objectIndent)
+		if unit == "" || unit == indent {
+			unit = "  "
+		}
+		marshalled, err = json.MarshalIndent(value, indent, unit)
+	} else {
+		marshalled, err = json.Marshal(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	member := string(key) + ": " + string(marshalled)
+
+	var out []byte
+	if lastKey == -1 {
+		out = append(out, content[:objectStart+1]...)
+		out = append(out, "\n"+indent+member+"\n"+objectIndent...)
+		out = append(out, content[objectEnd:]...)
+	} else {
+		out = append(out, content[:lastValEnd]...)
+		out = append(out, sep+member...)
+		out = append(out, content[lastValEnd:]...)
 	}
+	return out, nil
+}
+
+// lineStart returns the offset of the start of the line containing offset.
+func lineStart(content []byte, offset int) int {
+	return bytes.LastIndexByte(content[:offset], '\n') + 1
+}
+
+// lineIndent returns the leading white space of the line containing offset.
+func lineIndent(content []byte, offset int) string {
+	line := content[lineStart(content, offset):]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+// stdin is shared by all the prompts so that no buffered input is lost.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine prints prompt to stderr and reads a line from stdin.
+func readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readSecretLine is the same as readLine, but it tries to disable terminal
+// echo while reading. That only works on Unix-like systems with stty.
+func readSecretLine(prompt string) (string, error) {
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	return readLine(prompt)
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
 }
@@ -5,11 +5,18 @@
 
 package archiver
 
-import "os"
+import (
+	"io"
+	"os"
+)
 
 type Options interface {
 	Verbose() bool
 	Dry() bool
+
+	// ProgressOutput returns where to report the archiving progress,
+	// nil meaning that the progress is not to be reported.
+	ProgressOutput() io.Writer
 }
 
 type Archiver interface {
@@ -20,12 +27,15 @@ type ArchiverType string
 
 const (
 	TgzArchiverType ArchiverType = "tar.gz"
+	ZipArchiverType ArchiverType = "zip"
 )
 
 func New(typ ArchiverType, opts Options) (Archiver, error) {
 	switch typ {
 	case TgzArchiverType:
 		return newTgzArchiver(opts), nil
+	case ZipArchiverType:
+		return newZipArchiver(opts), nil
 	}
 
 	return nil, ErrUnknownArchiverType
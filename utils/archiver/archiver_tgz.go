@@ -14,6 +14,8 @@ import (
 	"os"
 	"path/filepath"
 	"unicode/utf8"
+
+	"github.com/tchap/salsa/utils/progress"
 )
 
 type tgzArchiver struct {
@@ -59,6 +61,19 @@ func (archiver *tgzArchiver) Archive(srcDir string) (archive *os.File, err error
 		fmt.Println("Packing artifacts")
 	}
 
+	// Count the files to be packed so that the progress can be reported.
+	var reporter *progress.Reporter
+	if out := archiver.opts.ProgressOutput(); out != nil {
+		total, err := countFiles(srcDir)
+		if err != nil {
+			gzipWriter.Close()
+			ar.Close()
+			os.Remove(ar.Name())
+			return nil, err
+		}
+		reporter = progress.NewReporter(out, "Packing artifacts", total, progress.Items)
+	}
+
 	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
 		// Stop on error.
 		if err != nil {
@@ -120,8 +135,14 @@ func (archiver *tgzArchiver) Archive(srcDir string) (archive *os.File, err error
 		if !archiver.opts.Dry() {
 			_, err = io.Copy(tarWriter, file)
 		}
+		if reporter != nil {
+			reporter.Add(1)
+		}
 		return err
 	})
+	if reporter != nil {
+		reporter.Finish()
+	}
 	if err != nil {
 		tarWriter.Close()
 		gzipWriter.Close()
@@ -160,3 +181,18 @@ func (archiver *tgzArchiver) Archive(srcDir string) (archive *os.File, err error
 	// Return the archive file, open and set to offset 0.
 	return ar, nil
 }
+
+// countFiles returns the number of regular files in the srcDir tree.
+func countFiles(srcDir string) (int64, error) {
+	var count int64
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package archiver
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tchap/salsa/utils/progress"
+)
+
+type zipArchiver struct {
+	opts Options
+}
+
+func newZipArchiver(opts Options) *zipArchiver {
+	return &zipArchiver{opts}
+}
+
+func (archiver *zipArchiver) Archive(srcDir string) (archive *os.File, err error) {
+	// Make sure the artifacts source directory exists and is not empty.
+	dir, err := os.Open(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	info, err := dir.Readdir(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(info) == 0 {
+		return nil, ErrNoArtifacts
+	}
+
+	// Pack the artifacts directory. The archive is created in the temporary
+	// directory so that it cannot end up inside srcDir and pack itself.
+	ar, err := ioutil.TempFile("", "artifacts_archive_")
+	if err != nil {
+		return nil, err
+	}
+
+	zipWriter := zip.NewWriter(ar)
+
+	if archiver.opts.Verbose() {
+		fmt.Println("Packing artifacts")
+	}
+
+	// Count the files to be packed so that the progress can be reported.
+	var reporter *progress.Reporter
+	if out := archiver.opts.ProgressOutput(); out != nil {
+		total, err := countFiles(srcDir)
+		if err != nil {
+			zipWriter.Close()
+			ar.Close()
+			os.Remove(ar.Name())
+			return nil, err
+		}
+		reporter = progress.NewReporter(out, "Packing artifacts", total, progress.Items)
+	}
+
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		// Stop on error.
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		// Skip root.
+		if relative == "." {
+			return nil
+		}
+
+		// Prepare zip header.
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			header.Method = zip.Deflate
+		}
+
+		// Append a trailing slash if this is a directory.
+		if info.IsDir() {
+			relative = fmt.Sprintf("%v%c", relative, os.PathSeparator)
+		}
+
+		if archiver.opts.Verbose() {
+			fmt.Println("   ", relative)
+		}
+
+		// Zip always uses '/' as the separator.
+		header.Name = filepath.ToSlash(relative)
+
+		// Write zip header.
+		w, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		// Do not write directories.
+		if info.IsDir() {
+			return nil
+		}
+
+		// Open the artifacts file.
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// Copy the file into the archive.
+		if !archiver.opts.Dry() {
+			_, err = io.Copy(w, file)
+		}
+		if reporter != nil {
+			reporter.Add(1)
+		}
+		return err
+	})
+	if reporter != nil {
+		reporter.Finish()
+	}
+	if err != nil {
+		zipWriter.Close()
+		ar.Close()
+		os.Remove(ar.Name())
+		return nil, err
+	}
+
+	if archiver.opts.Verbose() {
+		fmt.Println("Archive created")
+	}
+
+	// Make sure we close zip writer properly.
+	if err := zipWriter.Close(); err != nil {
+		ar.Close()
+		os.Remove(ar.Name())
+		return nil, err
+	}
+
+	// Rewind to the beginning of the archive, otherwise the following reads
+	// will return no data at all.
+	if _, err := ar.Seek(0, os.SEEK_SET); err != nil {
+		ar.Close()
+		os.Remove(ar.Name())
+		return nil, err
+	}
+
+	// Return the archive file, open and set to offset 0.
+	return ar, nil
+}
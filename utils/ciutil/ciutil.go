@@ -0,0 +1,191 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package ciutil detects the branch, build number, commit and pull request
+// being built from the environment of common CI servers, falling back to
+// reading the git repository in the working directory.
+package ciutil
+
+import (
+	"os"
+	"strings"
+)
+
+// Build describes the build being run. The fields are empty when unknown.
+type Build struct {
+	// Provider is the name of the detected CI server, empty when none.
+	Provider    string
+	Branch      string
+	BuildNumber string
+	Commit      string
+	PullRequest string
+}
+
+// provider describes how to detect a CI server and read its environment.
+type provider struct {
+	name   string
+	detect func(getenv func(string) string) bool
+	read   func(getenv func(string) string, build *Build)
+}
+
+var providers = []provider{
+	{
+		name:   "Jenkins",
+		detect: isSet("JENKINS_URL"),
+		read: func(getenv func(string) string, build *Build) {
+			// Multibranch pipelines set CHANGE_* for pull requests
+			// and BRANCH_NAME, plain jobs set GIT_BRANCH.
+			build.Branch = first(getenv("CHANGE_BRANCH"), getenv("BRANCH_NAME"),
+				strings.TrimPrefix(getenv("GIT_BRANCH"), "origin/"))
+			build.BuildNumber = getenv("BUILD_NUMBER")
+			build.Commit = getenv("GIT_COMMIT")
+			build.PullRequest = getenv("CHANGE_ID")
+		},
+	},
+	{
+		name:   "GitLab CI",
+		detect: isSet("GITLAB_CI"),
+		read: func(getenv func(string) string, build *Build) {
+			build.Branch = first(getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"),
+				getenv("CI_COMMIT_BRANCH"), getenv("CI_COMMIT_REF_NAME"))
+			build.BuildNumber = getenv("CI_PIPELINE_IID")
+			build.Commit = getenv("CI_COMMIT_SHA")
+			build.PullRequest = getenv("CI_MERGE_REQUEST_IID")
+		},
+	},
+	{
+		name:   "GitHub Actions",
+		detect: isSet("GITHUB_ACTIONS"),
+		read: func(getenv func(string) string, build *Build) {
+			// GITHUB_HEAD_REF is only set for pull requests.
+			ref := getenv("GITHUB_REF")
+			build.Branch = first(getenv("GITHUB_HEAD_REF"),
+				trimRef(ref, "refs/heads/"), getenv("GITHUB_REF_NAME"))
+			build.BuildNumber = getenv("GITHUB_RUN_NUMBER")
+			build.Commit = getenv("GITHUB_SHA")
+			if strings.HasPrefix(ref, "refs/pull/") {
+				build.PullRequest = strings.SplitN(ref[len("refs/pull/"):], "/", 2)[0]
+			}
+		},
+	},
+	{
+		name:   "Travis CI",
+		detect: isSet("TRAVIS"),
+		read: func(getenv func(string) string, build *Build) {
+			build.Branch = first(getenv("TRAVIS_PULL_REQUEST_BRANCH"), getenv("TRAVIS_BRANCH"))
+			build.BuildNumber = getenv("TRAVIS_BUILD_NUMBER")
+			build.Commit = getenv("TRAVIS_COMMIT")
+			build.PullRequest = pullRequest(getenv("TRAVIS_PULL_REQUEST"))
+		},
+	},
+	{
+		name:   "TeamCity",
+		detect: isSet("TEAMCITY_VERSION"),
+		read: func(getenv func(string) string, build *Build) {
+			// TeamCity does not export the branch by default,
+			// it is taken from git unless exported as $BRANCH.
+			build.BuildNumber = getenv("BUILD_NUMBER")
+			build.Commit = getenv("BUILD_VCS_NUMBER")
+		},
+	},
+	{
+		name:   "Bamboo",
+		detect: isSet("bamboo_buildNumber"),
+		read: func(getenv func(string) string, build *Build) {
+			build.Branch = first(getenv("bamboo_repository_pr_sourceBranch"),
+				getenv("bamboo_planRepository_branchName"),
+				getenv("bamboo_repository_git_branch"))
+			build.BuildNumber = getenv("bamboo_buildNumber")
+			build.Commit = getenv("bamboo_planRepository_revision")
+			build.PullRequest = getenv("bamboo_repository_pr_key")
+		},
+	},
+	{
+		name:   "Buildkite",
+		detect: isSet("BUILDKITE"),
+		read: func(getenv func(string) string, build *Build) {
+			build.Branch = getenv("BUILDKITE_BRANCH")
+			build.BuildNumber = getenv("BUILDKITE_BUILD_NUMBER")
+			build.Commit = getenv("BUILDKITE_COMMIT")
+			build.PullRequest = pullRequest(getenv("BUILDKITE_PULL_REQUEST"))
+		},
+	},
+}
+
+// Detect detects the build using the process environment and the git
+// repository in the current working directory.
+//
+// $BRANCH and $BUILD_NUMBER always win when set. Otherwise the variables
+// of the detected CI server are used. The branch and commit that are still
+// unknown are read from .git directly.
+func Detect() *Build {
+	build := detect(os.Getenv)
+
+	if build.Branch == "" || build.Commit == "" {
+		if wd, err := os.Getwd(); err == nil {
+			if head, err := ReadHead(wd); err == nil {
+				if build.Branch == "" {
+					build.Branch = head.Branch
+				}
+				if build.Commit == "" {
+					build.Commit = head.Commit
+				}
+			}
+		}
+	}
+	return build
+}
+
+func detect(getenv func(string) string) *Build {
+	build := new(Build)
+	for _, p := range providers {
+		if p.detect(getenv) {
+			build.Provider = p.name
+			p.read(getenv, build)
+			break
+		}
+	}
+
+	if branch := getenv("BRANCH"); branch != "" {
+		build.Branch = branch
+	}
+	if buildNum := getenv("BUILD_NUMBER"); buildNum != "" {
+		build.BuildNumber = buildNum
+	}
+	return build
+}
+
+func isSet(key string) func(getenv func(string) string) bool {
+	return func(getenv func(string) string) bool {
+		v := getenv(key)
+		return v != "" && v != "false"
+	}
+}
+
+// first returns the first non-empty value.
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func trimRef(ref, prefix string) string {
+	if strings.HasPrefix(ref, prefix) {
+		return ref[len(prefix):]
+	}
+	return ""
+}
+
+// pullRequest handles the CI servers setting the pull request variable
+// to "false" for branch builds.
+func pullRequest(v string) string {
+	if v == "false" {
+		return ""
+	}
+	return v
+}
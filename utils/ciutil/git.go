@@ -0,0 +1,203 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package ciutil
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoRepository is returned by ReadHead when no git repository is found.
+var ErrNoRepository = errors.New("git repository not found")
+
+// Head describes HEAD of a git repository.
+type Head struct {
+	// Branch is empty when HEAD is detached.
+	Branch string
+	// Commit is empty when the branch has no commits yet.
+	Commit string
+}
+
+// ReadHead reads HEAD of the git repository containing dir, walking up
+// the directory tree to find it. The git binary is not required.
+func ReadHead(dir string) (*Head, error) {
+	gitDir, commonDir, err := findGitDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return nil, err
+	}
+	head := strings.TrimSpace(string(content))
+
+	// Detached HEAD contains the commit hash directly.
+	if !strings.HasPrefix(head, "ref:") {
+		return &Head{Commit: head}, nil
+	}
+
+	ref := strings.TrimSpace(head[len("ref:"):])
+	commit, err := resolveRef(commonDir, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &Head{
+		Branch: strings.TrimPrefix(ref, "refs/heads/"),
+		Commit: commit,
+	}, nil
+}
+
+// findGitDir returns the git directory of the repository containing dir and
+// the common directory, which differs from the git directory for worktrees.
+func findGitDir(dir string) (gitDir, commonDir string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	for {
+		path := filepath.Join(dir, ".git")
+		info, err := os.Stat(path)
+		switch {
+		case err == nil && info.IsDir():
+			return path, path, nil
+
+		case err == nil:
+			// Worktrees and submodules have .git file pointing to the git dir.
+			gitDir, err := readGitFile(path)
+			if err != nil {
+				return "", "", err
+			}
+			commonDir := gitDir
+			if content, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+				commonDir = strings.TrimSpace(string(content))
+				if !filepath.IsAbs(commonDir) {
+					commonDir = filepath.Join(gitDir, commonDir)
+				}
+			}
+			return gitDir, commonDir, nil
+
+		case !os.IsNotExist(err):
+			return "", "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", ErrNoRepository
+		}
+		dir = parent
+	}
+}
+
+func readGitFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", errors.New("invalid .git file: " + path)
+	}
+
+	gitDir := strings.TrimSpace(line[len("gitdir:"):])
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	return gitDir, nil
+}
+
+// resolveRef returns the commit ref points to, looking into the loose refs
+// first and into packed-refs afterwards. An empty string is returned when
+// the ref does not exist, which is the case for a branch with no commits.
+func resolveRef(gitDir, ref string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref)))
+	switch {
+	case err == nil:
+		return strings.TrimSpace(string(content)), nil
+	case !os.IsNotExist(err):
+		return "", err
+	}
+
+	file, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// TagExists returns true if the tag called name exists in the git repository
+// containing dir.
+func TagExists(dir, name string) (bool, error) {
+	_, commonDir, err := findGitDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	commit, err := resolveRef(commonDir, "refs/tags/"+name)
+	if err != nil {
+		return false, err
+	}
+	return commit != "", nil
+}
+
+// CreateTag creates the lightweight tag called name in the git repository
+// containing dir, pointing to the current HEAD. The tag must not exist yet.
+// The ref is written directly, the git binary is not required.
+func CreateTag(dir, name string) error {
+	head, err := ReadHead(dir)
+	if err != nil {
+		return err
+	}
+	if head.Commit == "" {
+		return errors.New("HEAD does not point to any commit")
+	}
+
+	_, commonDir, err := findGitDir(dir)
+	if err != nil {
+		return err
+	}
+
+	ref := "refs/tags/" + name
+	commit, err := resolveRef(commonDir, ref)
+	if err != nil {
+		return err
+	}
+	if commit != "" {
+		return errors.New("tag already exists: " + name)
+	}
+
+	path := filepath.Join(commonDir, filepath.FromSlash(ref))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(head.Commit + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
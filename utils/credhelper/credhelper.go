@@ -0,0 +1,202 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package credhelper implements credential helpers, that is external sources
+// of credentials similar to what git uses for credential.helper.
+//
+// An external helper is an executable that is invoked with a single argument,
+// which is one of get, store and erase. The helper reads key=value lines from
+// stdin until a blank line is encountered. For get, it prints the credentials
+// to stdout in the same format. The keys are protocol, host, username and
+// password, which means that git credential helpers can be used as well.
+package credhelper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// NetrcHelperName is the name of the built-in helper reading ~/.netrc.
+const NetrcHelperName = "netrc"
+
+var ErrNotFound = errors.New("credentials not found")
+
+// Helper is a source of credentials keyed by host.
+type Helper interface {
+	// Get returns the credentials for host, ErrNotFound if there are none.
+	Get(host string) (username, password string, err error)
+
+	// Store saves the credentials for host.
+	Store(host, username, password string) error
+
+	// Erase removes the credentials for host.
+	Erase(host string) error
+}
+
+// New returns the helper called name. It is either the built-in netrc helper,
+// or a command line to be executed, the arguments being separated by spaces.
+func New(name string) (Helper, error) {
+	if name == NetrcHelperName {
+		return NewNetrc(""), nil
+	}
+
+	args := strings.Fields(name)
+	if len(args) == 0 {
+		return nil, errors.New("empty credential helper")
+	}
+	return &execHelper{args}, nil
+}
+
+type execHelper struct {
+	args []string
+}
+
+func (helper *execHelper) Get(host string) (username, password string, err error) {
+	out, err := helper.run("get", map[string]string{"host": host})
+	if err != nil {
+		return "", "", err
+	}
+
+	attrs, err := readAttributes(bytes.NewReader(out))
+	if err != nil {
+		return "", "", err
+	}
+
+	username, password = attrs["username"], attrs["password"]
+	if username == "" && password == "" {
+		return "", "", ErrNotFound
+	}
+	return username, password, nil
+}
+
+func (helper *execHelper) Store(host, username, password string) error {
+	_, err := helper.run("store", map[string]string{
+		"host":     host,
+		"username": username,
+		"password": password,
+	})
+	return err
+}
+
+func (helper *execHelper) Erase(host string) error {
+	_, err := helper.run("erase", map[string]string{"host": host})
+	return err
+}
+
+func (helper *execHelper) run(action string, attrs map[string]string) ([]byte, error) {
+	var stdin bytes.Buffer
+	fmt.Fprintln(&stdin, "protocol=https")
+	for _, key := range []string{"host", "username", "password"} {
+		if value, ok := attrs[key]; ok {
+			fmt.Fprintf(&stdin, "%v=%v\n", key, value)
+		}
+	}
+	fmt.Fprintln(&stdin)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(helper.args[0], append(helper.args[1:], action)...)
+	cmd.Stdin = &stdin
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("credential helper %v: %v: %v", helper.args[0], err, msg)
+		}
+		return nil, fmt.Errorf("credential helper %v: %v", helper.args[0], err)
+	}
+	return out, nil
+}
+
+func readAttributes(r io.Reader) (map[string]string, error) {
+	attrs := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid credential helper output line: %q", line)
+		}
+		attrs[parts[0]] = parts[1]
+	}
+	return attrs, scanner.Err()
+}
+
+// Credentials implement httputil.Credentials on top of a Helper.
+//
+// They also implement httputil.CredentialsFeedback, so that the credentials
+// obtained from the helper are erased when rejected by the server, while the
+// credentials obtained elsewhere are stored using the helper once accepted.
+type Credentials struct {
+	helper     Helper
+	host       string
+	username   string
+	password   string
+	fromHelper bool
+	once       sync.Once
+}
+
+// Lookup gets the credentials for host from helper.
+func Lookup(helper Helper, host string) (*Credentials, error) {
+	username, password, err := helper.Get(host)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{
+		helper:     helper,
+		host:       host,
+		username:   username,
+		password:   password,
+		fromHelper: true,
+	}, nil
+}
+
+// Wrap returns credentials that are stored using helper once accepted.
+func Wrap(helper Helper, host, username, password string) *Credentials {
+	return &Credentials{
+		helper:   helper,
+		host:     host,
+		username: username,
+		password: password,
+	}
+}
+
+func (cred *Credentials) Username() string {
+	return cred.username
+}
+
+func (cred *Credentials) Password() string {
+	return cred.password
+}
+
+// Accepted stores the credentials unless they came from the helper.
+// Errors are ignored, storing the credentials is just an optimisation.
+func (cred *Credentials) Accepted() {
+	if cred.fromHelper {
+		return
+	}
+	cred.once.Do(func() {
+		cred.helper.Store(cred.host, cred.username, cred.password)
+	})
+}
+
+// Rejected erases the credentials in case they came from the helper.
+func (cred *Credentials) Rejected() {
+	if !cred.fromHelper {
+		return
+	}
+	cred.once.Do(func() {
+		cred.helper.Erase(cred.host)
+	})
+}
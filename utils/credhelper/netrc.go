@@ -0,0 +1,149 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package credhelper
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// Netrc is a read-only Helper reading credentials from a .netrc file.
+type Netrc struct {
+	path string
+}
+
+// NewNetrc returns a helper reading the file at path. In case path is empty,
+// $NETRC is used, then $HOME/.netrc and $HOME/_netrc.
+func NewNetrc(path string) *Netrc {
+	return &Netrc{path}
+}
+
+func (netrc *Netrc) Get(host string) (username, password string, err error) {
+	path, err := netrc.filename()
+	if err != nil {
+		return "", "", err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", ErrNotFound
+		}
+		return "", "", err
+	}
+
+	// Try the host including the port first, then just the hostname.
+	machines := []string{host}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		machines = append(machines, hostname)
+	}
+
+	entries := parseNetrc(string(content))
+	for _, machine := range machines {
+		if entry, ok := entries[machine]; ok {
+			return entry.login, entry.password, nil
+		}
+	}
+	if entry, ok := entries[""]; ok {
+		return entry.login, entry.password, nil
+	}
+	return "", "", ErrNotFound
+}
+
+// Store is a no-op, .netrc is never modified.
+func (netrc *Netrc) Store(host, username, password string) error {
+	return nil
+}
+
+// Erase is a no-op, .netrc is never modified.
+func (netrc *Netrc) Erase(host string) error {
+	return nil
+}
+
+func (netrc *Netrc) filename() (string, error) {
+	if netrc.path != "" {
+		return netrc.path, nil
+	}
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(u.HomeDir, ".netrc")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return filepath.Join(u.HomeDir, "_netrc"), nil
+	}
+	return path, nil
+}
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// parseNetrc parses the content of a .netrc file. The default entry is
+// stored under the empty key. Macro definitions are skipped.
+func parseNetrc(content string) map[string]netrcEntry {
+	entries := make(map[string]netrcEntry)
+
+	var (
+		machine string
+		entry   netrcEntry
+		inEntry bool
+	)
+	flush := func() {
+		if inEntry {
+			if _, ok := entries[machine]; !ok {
+				entries[machine] = entry
+			}
+		}
+		machine, entry, inEntry = "", netrcEntry{}, false
+	}
+
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		tokens := strings.Fields(lines[i])
+		for j := 0; j < len(tokens); j++ {
+			next := func() string {
+				if j+1 < len(tokens) {
+					j++
+					return tokens[j]
+				}
+				return ""
+			}
+
+			switch tokens[j] {
+			case "machine":
+				flush()
+				machine, inEntry = next(), true
+			case "default":
+				flush()
+				inEntry = true
+			case "login":
+				entry.login = next()
+			case "password":
+				entry.password = next()
+			case "account":
+				next()
+			case "macdef":
+				// Skip the macro definition, which ends with an empty line.
+				flush()
+				for i++; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				}
+				j = len(tokens)
+			}
+		}
+	}
+	flush()
+	return entries
+}
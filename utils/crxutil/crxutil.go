@@ -6,38 +6,75 @@
 package crxutil
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 )
 
 const MagicNumber = "Cr24"
 
-var ErrNotCrx = errors.New("not a crx file")
+// The supported CRX format versions.
+const (
+	Version2 = 2
+	Version3 = 3
+)
+
+// MaxHeaderSize limits the size of the header fields so that a corrupted
+// file cannot make us allocate arbitrary amounts of memory.
+const MaxHeaderSize = 1 << 20
+
+var (
+	ErrNotCrx             = errors.New("not a crx file")
+	ErrUnsupportedVersion = errors.New("unsupported crx version")
+)
 
+// CrxFile is a parsed CRX file. The header has been read already,
+// ZipFile is positioned at the beginning of the zip archive.
+//
+// For CRX3 files PublicKey and Signature are taken from the RSA proof that
+// matches the CRX ID in the signed header data, see Header for all the proofs.
 type CrxFile struct {
 	MagicNumber string
 	Version     uint32
 	PublicKey   []byte
 	Signature   []byte
+	Header      *Header
 	ZipFile     io.ReadCloser
 }
 
+// Header is the CRX3 file header.
+type Header struct {
+	// Raw is the serialized CrxFileHeader protocol buffer.
+	Raw []byte
+
+	SHA256WithRSA   []Proof
+	SHA256WithECDSA []Proof
+
+	// SignedHeaderData is the serialized SignedData protocol buffer,
+	// which is signed together with the zip archive by every proof.
+	SignedHeaderData []byte
+
+	// CrxID is the crx_id field of SignedHeaderData, the first 16 bytes
+	// of SHA-256 of the developer public key.
+	CrxID []byte
+}
+
+// Proof is a public key and a signature made using the private key.
+type Proof struct {
+	PublicKey []byte
+	Signature []byte
+}
+
 func NewCrxFile(rc io.ReadCloser) (*CrxFile, error) {
 	crx := new(CrxFile)
-	var (
-		magicNumber  []byte = make([]byte, 4)
-		publicKeyLen uint32
-		signatureLen uint32
-	)
 
 	// Read magic number.
-	for i := 0; i != len(magicNumber); {
-		n, err := rc.Read(magicNumber[i:])
-		if err != nil {
-			return nil, err
-		}
-		i += n
+	magicNumber := make([]byte, 4)
+	if _, err := io.ReadFull(rc, magicNumber); err != nil {
+		return nil, err
 	}
 	crx.MagicNumber = string(magicNumber)
 	if crx.MagicNumber != MagicNumber {
@@ -49,37 +86,152 @@ func NewCrxFile(rc io.ReadCloser) (*CrxFile, error) {
 		return nil, err
 	}
 
+	switch crx.Version {
+	case Version2:
+		if err := crx.readHeader2(rc); err != nil {
+			return nil, err
+		}
+	case Version3:
+		if err := crx.readHeader3(rc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+
+	// What is left is the zip file.
+	crx.ZipFile = rc
+	return crx, nil
+}
+
+// readHeader2 reads the CRX2 header following the version:
+// public key length, signature length, public key, signature.
+func (crx *CrxFile) readHeader2(r io.Reader) error {
+	var publicKeyLen, signatureLen uint32
+
 	// Read public key length.
-	if err := binary.Read(rc, binary.LittleEndian, &publicKeyLen); err != nil {
-		return nil, err
+	if err := binary.Read(r, binary.LittleEndian, &publicKeyLen); err != nil {
+		return err
 	}
 
 	// Read signature length.
-	if err := binary.Read(rc, binary.LittleEndian, &signatureLen); err != nil {
-		return nil, err
+	if err := binary.Read(r, binary.LittleEndian, &signatureLen); err != nil {
+		return err
+	}
+
+	if publicKeyLen > MaxHeaderSize || signatureLen > MaxHeaderSize {
+		return errors.New("crx header too large")
 	}
 
 	// Read the public key.
 	crx.PublicKey = make([]byte, publicKeyLen)
-	for i := uint32(0); i != publicKeyLen; {
-		n, err := rc.Read(crx.PublicKey[i:])
-		if err != nil {
-			return nil, err
-		}
-		i += uint32(n)
+	if _, err := io.ReadFull(r, crx.PublicKey); err != nil {
+		return err
 	}
 
 	// Read the signature.
 	crx.Signature = make([]byte, signatureLen)
-	for i := uint32(0); i != signatureLen; {
-		n, err := rc.Read(crx.Signature[i:])
-		if err != nil {
-			return nil, err
+	if _, err := io.ReadFull(r, crx.Signature); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readHeader3 reads the CRX3 header following the version:
+// header length and the CrxFileHeader protocol buffer.
+func (crx *CrxFile) readHeader3(r io.Reader) error {
+	var headerLen uint32
+	if err := binary.Read(r, binary.LittleEndian, &headerLen); err != nil {
+		return err
+	}
+	if headerLen > MaxHeaderSize {
+		return errors.New("crx header too large")
+	}
+
+	raw := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return err
+	}
+
+	header, err := ParseHeader(raw)
+	if err != nil {
+		return err
+	}
+	crx.Header = header
+
+	for _, proof := range header.SHA256WithRSA {
+		sum := sha256.Sum256(proof.PublicKey)
+		if bytes.Equal(sum[:16], header.CrxID) {
+			crx.PublicKey = proof.PublicKey
+			crx.Signature = proof.Signature
+			break
 		}
-		i += uint32(n)
 	}
+	return nil
+}
 
-	// What is left is the zip file.
-	crx.ZipFile = rc
-	return crx, nil
+// Protocol buffer field numbers, see crx3.proto in Chromium.
+const (
+	fieldHeaderSHA256WithRSA    = 2
+	fieldHeaderSHA256WithECDSA  = 3
+	fieldHeaderSignedHeaderData = 10000
+
+	fieldProofPublicKey = 1
+	fieldProofSignature = 2
+
+	fieldSignedDataCrxID = 1
+)
+
+// ParseHeader parses the serialized CrxFileHeader protocol buffer.
+func ParseHeader(raw []byte) (*Header, error) {
+	header := &Header{Raw: raw}
+
+	err := parseMessage(raw, func(field int, value []byte) error {
+		switch field {
+		case fieldHeaderSHA256WithRSA, fieldHeaderSHA256WithECDSA:
+			proof, err := parseProof(value)
+			if err != nil {
+				return err
+			}
+			if field == fieldHeaderSHA256WithRSA {
+				header.SHA256WithRSA = append(header.SHA256WithRSA, *proof)
+			} else {
+				header.SHA256WithECDSA = append(header.SHA256WithECDSA, *proof)
+			}
+		case fieldHeaderSignedHeaderData:
+			header.SignedHeaderData = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid crx header: %v", err)
+	}
+
+	err = parseMessage(header.SignedHeaderData, func(field int, value []byte) error {
+		if field == fieldSignedDataCrxID {
+			header.CrxID = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid crx signed header data: %v", err)
+	}
+	return header, nil
+}
+
+func parseProof(raw []byte) (*Proof, error) {
+	var proof Proof
+	err := parseMessage(raw, func(field int, value []byte) error {
+		switch field {
+		case fieldProofPublicKey:
+			proof.PublicKey = value
+		case fieldProofSignature:
+			proof.Signature = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &proof, nil
 }
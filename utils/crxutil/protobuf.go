@@ -0,0 +1,86 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package crxutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// This file contains the minimal protocol buffer support necessary to read
+// and write the CRX3 header.
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protocol buffer")
+
+// parseMessage calls fn for every length-delimited field of the serialized
+// protocol buffer message. The fields of the other wire types are skipped.
+func parseMessage(raw []byte, fn func(field int, value []byte) error) error {
+	for len(raw) != 0 {
+		key, n := binary.Uvarint(raw)
+		if n <= 0 {
+			return errTruncated
+		}
+		raw = raw[n:]
+
+		field, wireType := int(key>>3), int(key&7)
+		if field == 0 {
+			return errors.New("invalid protocol buffer field number 0")
+		}
+
+		switch wireType {
+		case wireVarint:
+			if _, n = binary.Uvarint(raw); n <= 0 {
+				return errTruncated
+			}
+			raw = raw[n:]
+
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(raw) < size {
+				return errTruncated
+			}
+			raw = raw[size:]
+
+		case wireBytes:
+			length, n := binary.Uvarint(raw)
+			if n <= 0 || length > uint64(len(raw)-n) {
+				return errTruncated
+			}
+			value := raw[n : n+int(length)]
+			raw = raw[n+int(length):]
+
+			if err := fn(field, value); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported protocol buffer wire type %v", wireType)
+		}
+	}
+	return nil
+}
+
+// appendBytesField appends a length-delimited field to buf.
+func appendBytesField(buf []byte, field int, value []byte) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(varint[:], uint64(field)<<3|wireBytes)
+	buf = append(buf, varint[:n]...)
+	n = binary.PutUvarint(varint[:], uint64(len(value)))
+	buf = append(buf, varint[:n]...)
+	return append(buf, value...)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package crxutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrInvalidSignature = errors.New("invalid crx signature")
+
+// Verify reads the zip archive from crx.ZipFile and checks the signatures
+// over it. It returns the extension ID on success.
+//
+// For CRX2 files the signature must be valid for PublicKey. For CRX3 files
+// all the proofs must be valid and one of the RSA proofs must be made using
+// the key the CRX ID in the signed header data is derived from.
+//
+// crx.ZipFile is consumed, wrap it using io.TeeReader before calling Verify
+// in case the archive is needed as well.
+func Verify(crx *CrxFile) (string, error) {
+	switch crx.Version {
+	case Version2:
+		return verify2(crx)
+	case Version3:
+		return verify3(crx)
+	default:
+		return "", ErrUnsupportedVersion
+	}
+}
+
+func verify2(crx *CrxFile) (string, error) {
+	key, err := parseRSAPublicKey(crx.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, crx.ZipFile); err != nil {
+		return "", err
+	}
+
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA1, h.Sum(nil), crx.Signature); err != nil {
+		return "", ErrInvalidSignature
+	}
+	return ExtensionID(crx.PublicKey), nil
+}
+
+func verify3(crx *CrxFile) (string, error) {
+	header := crx.Header
+	if len(header.CrxID) != 16 {
+		return "", errors.New("invalid crx header: crx_id missing")
+	}
+	if len(header.SHA256WithRSA)+len(header.SHA256WithECDSA) == 0 {
+		return "", errors.New("invalid crx header: no proofs")
+	}
+
+	// All the proofs sign the same data, hash it once.
+	h := sha256.New()
+	h.Write([]byte(signedDataPrefix))
+	binary.Write(h, binary.LittleEndian, uint32(len(header.SignedHeaderData)))
+	h.Write(header.SignedHeaderData)
+	if _, err := io.Copy(h, crx.ZipFile); err != nil {
+		return "", err
+	}
+	digest := h.Sum(nil)
+
+	var developerKeyFound bool
+	for i, proof := range header.SHA256WithRSA {
+		key, err := parseRSAPublicKey(proof.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("RSA proof %v: %v", i, err)
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, proof.Signature); err != nil {
+			return "", fmt.Errorf("RSA proof %v: %v", i, ErrInvalidSignature)
+		}
+
+		if sum := sha256.Sum256(proof.PublicKey); bytes.Equal(sum[:16], header.CrxID) {
+			developerKeyFound = true
+		}
+	}
+
+	for i, proof := range header.SHA256WithECDSA {
+		key, err := x509.ParsePKIXPublicKey(proof.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("ECDSA proof %v: %v", i, err)
+		}
+		ecdsaKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("ECDSA proof %v: not an ECDSA public key", i)
+		}
+		if !ecdsa.VerifyASN1(ecdsaKey, digest, proof.Signature) {
+			return "", fmt.Errorf("ECDSA proof %v: %v", i, ErrInvalidSignature)
+		}
+	}
+
+	if !developerKeyFound {
+		return "", errors.New("no proof matches the crx ID")
+	}
+	return encodeID(header.CrxID), nil
+}
+
+func parseRSAPublicKey(der []byte) (*rsa.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return rsaKey, nil
+}
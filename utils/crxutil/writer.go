@@ -0,0 +1,117 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package crxutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// signedDataPrefix is prepended to the signed data of CRX3 files.
+const signedDataPrefix = "CRX3 SignedData\x00"
+
+// WriteCrxFile is the counterpart of NewCrxFile. It writes the CRX file of
+// the given version containing zip into w, signed using key.
+//
+// zip is read twice, to compute the signature and to copy it into w.
+func WriteCrxFile(w io.Writer, zip io.ReadSeeker, version uint32, key *rsa.PrivateKey) error {
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(MagicNumber)
+
+	switch version {
+	case Version2:
+		signature, err := sign(key, crypto.SHA1, nil, zip)
+		if err != nil {
+			return err
+		}
+
+		binary.Write(&header, binary.LittleEndian, []uint32{
+			Version2, uint32(len(publicKey)), uint32(len(signature))})
+		header.Write(publicKey)
+		header.Write(signature)
+
+	case Version3:
+		sum := sha256.Sum256(publicKey)
+		signedData := appendBytesField(nil, fieldSignedDataCrxID, sum[:16])
+
+		var prefix bytes.Buffer
+		prefix.WriteString(signedDataPrefix)
+		binary.Write(&prefix, binary.LittleEndian, uint32(len(signedData)))
+		prefix.Write(signedData)
+
+		signature, err := sign(key, crypto.SHA256, prefix.Bytes(), zip)
+		if err != nil {
+			return err
+		}
+
+		var proof []byte
+		proof = appendBytesField(proof, fieldProofPublicKey, publicKey)
+		proof = appendBytesField(proof, fieldProofSignature, signature)
+
+		var crxHeader []byte
+		crxHeader = appendBytesField(crxHeader, fieldHeaderSHA256WithRSA, proof)
+		crxHeader = appendBytesField(crxHeader, fieldHeaderSignedHeaderData, signedData)
+
+		binary.Write(&header, binary.LittleEndian, []uint32{Version3, uint32(len(crxHeader))})
+		header.Write(crxHeader)
+
+	default:
+		return ErrUnsupportedVersion
+	}
+
+	if _, err := zip.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	if _, err := header.WriteTo(w); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, zip)
+	return err
+}
+
+// sign signs prefix followed by the content of zip using PKCS #1 v1.5.
+func sign(key *rsa.PrivateKey, hashFunc crypto.Hash, prefix []byte, zip io.ReadSeeker) ([]byte, error) {
+	if _, err := zip.Seek(0, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	h := hashFunc.New()
+	h.Write(prefix)
+	if _, err := io.Copy(h, zip); err != nil {
+		return nil, err
+	}
+	return rsa.SignPKCS1v15(rand.Reader, key, hashFunc, h.Sum(nil))
+}
+
+// ExtensionID returns the ID of the extension signed by publicKey, which is
+// the first 128 bits of SHA-256 of the DER-encoded key written using the
+// letters a to p as the hexadecimal digits.
+func ExtensionID(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return encodeID(sum[:16])
+}
+
+// encodeID writes id using the letters a to p as the hexadecimal digits.
+func encodeID(id []byte) string {
+	encoded := make([]byte, 2*len(id))
+	for i, b := range id {
+		encoded[2*i] = 'a' + b>>4
+		encoded[2*i+1] = 'a' + b&0x0f
+	}
+	return string(encoded)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package cryptoutil implements encryption of configuration values at rest.
+//
+// Values are encrypted using AES-256-GCM and stored as Prefix followed by
+// the base64-encoded nonce and ciphertext. The key is either derived from
+// a passphrase using PBKDF2-HMAC-SHA256 or computed from a key file.
+package cryptoutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Prefix marks encrypted values.
+const Prefix = "enc:"
+
+// KeySize is the size of the keys in bytes, selecting AES-256.
+const KeySize = 32
+
+var ErrDecrypt = errors.New("failed to decrypt: wrong key or corrupted value")
+
+// IsEncrypted returns true if value is an encrypted value.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// NewSalt returns n random bytes to be used as the salt for DeriveKey.
+func NewSalt(n int) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// DeriveKey derives a key from passphrase using PBKDF2 with HMAC-SHA256.
+func DeriveKey(passphrase string, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, []byte(passphrase))
+	hashLen := prf.Size()
+	numBlocks := (KeySize + hashLen - 1) / hashLen
+
+	key := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		// U_1 = PRF(passphrase, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		key = prf.Sum(key)
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+
+		// U_n = PRF(passphrase, U_{n-1}), T = U_1 ^ U_2 ^ ... ^ U_n
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return key[:KeySize]
+}
+
+// KeyFromFile computes the key from the content of a key file.
+func KeyFromFile(content []byte) []byte {
+	sum := sha256.Sum256(content)
+	return sum[:]
+}
+
+// Encrypt encrypts plaintext using key.
+func Encrypt(key []byte, plaintext string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts value as returned by Encrypt using key.
+func Decrypt(key []byte, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", errors.New("not an encrypted value")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(value[len(Prefix):])
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package flagutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSizeValue is a flag value representing a number of bytes. The value can
+// have a K, M or G suffix (optionally followed by B), binary prefixes are used.
+type ByteSizeValue struct {
+	N int64
+}
+
+func (bv *ByteSizeValue) Set(s string) error {
+	n, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	bv.N = n
+	return nil
+}
+
+func (bv *ByteSizeValue) Get() interface{} {
+	return bv.N
+}
+
+func (bv *ByteSizeValue) String() string {
+	return fmt.Sprintf("%v", bv.N)
+}
+
+// ParseByteSize parses s as described in ByteSizeValue.
+func ParseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "B")
+
+	multiplier := int64(1)
+	if len(str) != 0 {
+		switch str[len(str)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			str = str[:len(str)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid byte size: %v", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"net/http"
+)
+
+// Authorizer can be implemented by Credentials to authorize requests
+// in a different way than using Basic authentication.
+type Authorizer interface {
+	Authorize(req *http.Request) error
+}
+
+// TokenSource returns a valid token, refreshing it if necessary.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// NewBearerCredentials returns Credentials that authorize requests by setting
+// the Authorization header to a Bearer token taken from src.
+func NewBearerCredentials(src TokenSource) Credentials {
+	return &bearerCredentials{src}
+}
+
+type bearerCredentials struct {
+	src TokenSource
+}
+
+func (cred *bearerCredentials) Username() string {
+	return ""
+}
+
+func (cred *bearerCredentials) Password() string {
+	return ""
+}
+
+func (cred *bearerCredentials) Authorize(req *http.Request) error {
+	token, err := cred.src.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"io"
+	"net/http"
+)
+
+// Client is a HTTP client that authenticates all the requests it sends
+// using the given Credentials.
+type Client struct {
+	// Credentials to use for the requests, nil means no authentication.
+	Credentials Credentials
+
+	// Transport to use for the requests, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	// ProgressOutput, if not nil, is where the progress of the uploads
+	// and downloads made using Put and Get is reported.
+	ProgressOutput io.Writer
+
+	// Limits, if not nil, limit the uploads and downloads made using Put
+	// and Get.
+	Limits *Limits
+}
+
+// Do authenticates and sends req.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if c.Credentials != nil {
+		if authorizer, ok := c.Credentials.(Authorizer); ok {
+			if err := authorizer.Authorize(req); err != nil {
+				return nil, err
+			}
+		} else {
+			req.SetBasicAuth(c.Credentials.Username(), c.Credentials.Password())
+		}
+	}
+
+	client := http.Client{Transport: c.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if feedback, ok := c.Credentials.(CredentialsFeedback); ok {
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized:
+			feedback.Rejected()
+		case resp.StatusCode < 400:
+			feedback.Accepted()
+		}
+	}
+	return resp, nil
+}
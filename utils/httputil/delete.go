@@ -0,0 +1,26 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"net/http"
+)
+
+func (c *Client) Delete(URL string) (*http.Response, error) {
+	// Prepare the HTTP request.
+	req, err := http.NewRequest("DELETE", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the request.
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
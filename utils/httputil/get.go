@@ -6,44 +6,53 @@
 package httputil
 
 import (
-	"fmt"
 	"net/http"
+	"path"
+
+	"github.com/tchap/salsa/utils/progress"
 )
 
 func Get(URL string, cred Credentials) (*http.Response, error) {
+	client := &Client{Credentials: cred}
+	return client.Get(URL)
+}
+
+// Get sends a GET request. In case c.Limits is set, the transfer slot is held
+// until the response body is closed, so the body must be closed before any
+// other transfer is started by the same goroutine, otherwise it can block.
+func (c *Client) Get(URL string) (*http.Response, error) {
 	// Prepare the HTTP request.
 	req, err := http.NewRequest("GET", URL, nil)
 	if err != nil {
 		return nil, err
 	}
-	if cred != nil {
-		req.SetBasicAuth(cred.Username(), cred.Password())
+
+	// Wait for a transfer slot.
+	release := func() {}
+	if c.Limits != nil {
+		release = c.Limits.acquire()
 	}
 
-	var client http.Client
-	resp, err := client.Do(req)
+	resp, err := c.Do(req)
 	if err != nil {
-		resp.Body.Close()
+		release()
 		return nil, err
 	}
-	return resp, nil
-}
 
-type transport struct {
-	rt http.RoundTripper
-}
+	// Apply the rate limit, the slot is released once the body is closed.
+	if c.Limits != nil {
+		resp.Body = &limitedReadCloser{
+			Reader:  c.Limits.reader(resp.Body),
+			closer:  resp.Body,
+			release: release,
+		}
+	}
 
-func (t *transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	req.Header.Set("User-Agent", "curl/7.21.4 (universal-apple-darwin11.0) libcurl/7.21.4 OpenSSL/0.9.8y zlib/1.2.5")
-	req.Header.Set("Accept", "*/*")
-	fmt.Println("-------")
-	fmt.Println("REQUEST", req)
-	resp, err = t.rt.RoundTrip(req)
-	fmt.Println("-------")
-	fmt.Println("RESPONSE")
-	fmt.Println(resp)
-	fmt.Println("ERROR")
-	fmt.Println(err)
-	fmt.Println("-------")
-	return
+	// Report the download progress if requested.
+	if c.ProgressOutput != nil && resp.StatusCode < 300 {
+		reporter := progress.NewReporter(c.ProgressOutput,
+			"Downloading "+path.Base(req.URL.Path), resp.ContentLength, progress.Bytes)
+		resp.Body = progress.NewReader(resp.Body, reporter)
+	}
+	return resp, nil
 }
@@ -9,3 +9,28 @@ type Credentials interface {
 	Username() string
 	Password() string
 }
+
+// CredentialsFeedback can be implemented by Credentials to be notified
+// whether the server accepted the credentials or not.
+type CredentialsFeedback interface {
+	Accepted()
+	Rejected()
+}
+
+// NewCredentials returns Credentials that always return username and password.
+func NewCredentials(username, password string) Credentials {
+	return &credentials{username, password}
+}
+
+type credentials struct {
+	username string
+	password string
+}
+
+func (cred *credentials) Username() string {
+	return cred.username
+}
+
+func (cred *credentials) Password() string {
+	return cred.password
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limits limit the transfers made using Put and Get. A single Limits object
+// is supposed to be shared by all the clients, so that the transfers running
+// in parallel respect the limits together.
+type Limits struct {
+	bucket *tokenBucket
+	slots  chan struct{}
+}
+
+// NewLimits returns limits allowing bytesPerSecond in total and maxTransfers
+// concurrent transfers. Zero values mean no limit.
+func NewLimits(bytesPerSecond int64, maxTransfers int) *Limits {
+	limits := new(Limits)
+	if bytesPerSecond > 0 {
+		limits.bucket = newTokenBucket(bytesPerSecond)
+	}
+	if maxTransfers > 0 {
+		limits.slots = make(chan struct{}, maxTransfers)
+	}
+	return limits
+}
+
+// acquire blocks until a transfer can be started. The returned function
+// must be called once the transfer is finished.
+func (limits *Limits) acquire() (release func()) {
+	if limits.slots == nil {
+		return func() {}
+	}
+
+	limits.slots <- struct{}{}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-limits.slots })
+	}
+}
+
+// reader returns r limited by the rate limit.
+func (limits *Limits) reader(r io.Reader) io.Reader {
+	if limits.bucket == nil {
+		return r
+	}
+	return &limitedReader{r, limits.bucket}
+}
+
+// tokenBucket is a token bucket where a token represents a byte. Tokens can be
+// borrowed, the borrower then waits until the debt is repaid, which keeps
+// the bucket fair for the concurrent readers.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	// Allow bursts of 100 ms worth of data, but at least 1 KiB.
+	burst := float64(rate) / 10
+	if burst < 1024 {
+		burst = 1024
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// take takes n tokens, blocking until they are available.
+func (bucket *tokenBucket) take(n int) {
+	bucket.mu.Lock()
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+	bucket.tokens -= float64(n)
+	debt := bucket.tokens
+	bucket.mu.Unlock()
+
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / bucket.rate * float64(time.Second)))
+	}
+}
+
+type limitedReader struct {
+	r      io.Reader
+	bucket *tokenBucket
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// Do not read more than the burst at once to keep the rate smooth.
+	if max := int(lr.bucket.burst); len(p) > max {
+		p = p[:max]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		lr.bucket.take(n)
+	}
+	return n, err
+}
+
+// limitedReadCloser is a response body limited by Limits.
+type limitedReadCloser struct {
+	io.Reader
+	closer  io.Closer
+	release func()
+}
+
+func (rc *limitedReadCloser) Close() error {
+	rc.release()
+	return rc.closer.Close()
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var hrefRegexp = regexp.MustCompile(`(?i)<a\s+href="([^"]+)"`)
+
+// List returns the entries of the directory at URL as listed by the index
+// page the server generates, e.g. Nginx autoindex. Directory entries end
+// with a slash, the parent directory and any links leaving the directory
+// listed are skipped.
+func (c *Client) List(URL string) ([]string, error) {
+	if !strings.HasSuffix(URL, "/") {
+		URL += "/"
+	}
+
+	// Index pages are small, so Get is not used to avoid progress reporting.
+	req, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to list %v: %v", URL, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	for _, match := range hrefRegexp.FindAllSubmatch(body, -1) {
+		href := string(match[1])
+
+		// Skip sorting links, absolute links and links to parent directories.
+		if strings.ContainsAny(href, "?#:") || strings.HasPrefix(href, "/") ||
+			strings.HasPrefix(href, "..") {
+			continue
+		}
+
+		entry, err := url.QueryUnescape(strings.Replace(href, "+", "%2B", -1))
+		if err != nil {
+			return nil, err
+		}
+		if entry == "" || entry == "./" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
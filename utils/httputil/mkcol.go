@@ -0,0 +1,31 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"net/http"
+)
+
+// Mkcol sends a WebDAV MKCOL request to create the collection at URL.
+// The parent collection must exist already.
+//
+// Servers respond with 405 Method Not Allowed when the collection exists,
+// which is also what the servers not supporting MKCOL usually respond with.
+func (c *Client) Mkcol(URL string) (*http.Response, error) {
+	// Prepare the HTTP request.
+	req, err := http.NewRequest("MKCOL", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the request.
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
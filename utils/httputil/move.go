@@ -0,0 +1,44 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"net/http"
+)
+
+// Move sends a WebDAV MOVE request to move srcURL to dstURL. The destination
+// is replaced only when overwrite is set.
+//
+// Servers not supporting MOVE usually respond with 405 Method Not Allowed
+// or 501 Not Implemented, see MethodNotSupported.
+func (c *Client) Move(srcURL, dstURL string, overwrite bool) (*http.Response, error) {
+	// Prepare the HTTP request.
+	req, err := http.NewRequest("MOVE", srcURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Destination", dstURL)
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+
+	// Send the request.
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// MethodNotSupported returns true when resp signals that the server does not
+// support the request method.
+func MethodNotSupported(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusMethodNotAllowed ||
+		resp.StatusCode == http.StatusNotImplemented
+}
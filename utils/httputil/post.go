@@ -0,0 +1,25 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PostForm sends data URL-encoded as the request body. The response body
+// must be closed by the caller.
+func (c *Client) PostForm(URL string, data url.Values) (*http.Response, error) {
+	// Prepare the HTTP request.
+	req, err := http.NewRequest("POST", URL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.Do(req)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ProxyConfig specifies the proxies to use. The environment variables
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY (or their lowercase variants)
+// are used for the fields that are empty.
+type ProxyConfig struct {
+	HTTPProxy  string `json:"http"`
+	HTTPSProxy string `json:"https"`
+	NoProxy    string `json:"noProxy"`
+}
+
+// ProxyFunc returns a function to be used as http.Transport.Proxy.
+//
+// NoProxy is a comma-separated list of hosts to connect to directly. An entry
+// can be a domain name, which matches the subdomains as well, an IP address,
+// a CIDR block or "*" to disable proxies completely. Entries can contain
+// a port, in which case they only match requests to that port.
+func (cfg *ProxyConfig) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	var (
+		httpProxy  = firstNonEmpty(cfg.HTTPProxy, getenv("HTTP_PROXY"))
+		httpsProxy = firstNonEmpty(cfg.HTTPSProxy, getenv("HTTPS_PROXY"))
+		noProxy    = firstNonEmpty(cfg.NoProxy, getenv("NO_PROXY"))
+	)
+
+	return func(req *http.Request) (*url.URL, error) {
+		var proxy string
+		switch req.URL.Scheme {
+		case "http":
+			proxy = httpProxy
+		case "https":
+			proxy = httpsProxy
+		}
+		if proxy == "" || bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			// Allow proxies to be specified as just host:port.
+			proxyURL, err = url.Parse("http://" + proxy)
+		}
+		return proxyURL, err
+	}
+}
+
+// bypassProxy returns true if u matches noProxy.
+func bypassProxy(u *url.URL, noProxy string) bool {
+	host, port := u.Host, ""
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host, port = h, p
+	}
+	host = strings.ToLower(host)
+
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+
+		// CIDR blocks.
+		if _, block, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && block.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// Split the port, if any.
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		// IP addresses.
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		// Domain names, matching the subdomains as well.
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		entryHost = strings.TrimPrefix(entryHost, ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+func getenv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(key))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -9,16 +9,32 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
+
+	"github.com/tchap/salsa/utils/progress"
 )
 
 func Put(body io.Reader, URL string, cred Credentials) (*http.Response, error) {
+	client := &Client{Credentials: cred}
+	return client.Put(body, URL)
+}
+
+func (c *Client) Put(body io.Reader, URL string) (*http.Response, error) {
+	return c.PutWithHeader(body, URL, nil)
+}
+
+// PutWithHeader is the same as Put, but header is added to the request,
+// which can be used to send a conditional request using If-Match.
+func (c *Client) PutWithHeader(body io.Reader, URL string, header http.Header) (*http.Response, error) {
 	// Prepare the HTTP request.
 	req, err := http.NewRequest("PUT", URL, body)
 	if err != nil {
 		return nil, err
 	}
-	if cred != nil {
-		req.SetBasicAuth(cred.Username(), cred.Password())
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
 	}
 
 	// Try to set Content-Length in some more special cases.
@@ -32,9 +48,30 @@ func Put(body io.Reader, URL string, cred Credentials) (*http.Response, error) {
 		req.ContentLength = info.Size()
 	}
 
+	// Apply the transfer limits.
+	if c.Limits != nil && req.Body != nil {
+		release := c.Limits.acquire()
+		defer release()
+		req.Body = &limitedReadCloser{
+			Reader:  c.Limits.reader(req.Body),
+			closer:  req.Body,
+			release: func() {},
+		}
+	}
+
+	// Report the upload progress if requested.
+	if c.ProgressOutput != nil && req.Body != nil {
+		total := req.ContentLength
+		if total == 0 {
+			total = -1
+		}
+		reporter := progress.NewReporter(c.ProgressOutput,
+			"Uploading "+path.Base(req.URL.Path), total, progress.Bytes)
+		req.Body = progress.NewReader(req.Body, reporter)
+	}
+
 	// Send the request.
-	var client http.Client
-	resp, err := client.Do(req)
+	resp, err := c.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,239 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Headers that are never printed as they are.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// TraceTransport is a http.RoundTripper that logs the requests and responses
+// passing through it, including the timing of the connection phases and the
+// number of bytes transferred. The credentials are never printed.
+type TraceTransport struct {
+	// Transport is the underlying transport, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	// Output is where the trace is written.
+	Output io.Writer
+
+	// Redact, if set, is applied to the URLs and the header values before
+	// they are printed, so that any secrets in them can be masked. Headers
+	// such as Destination or Location contain URLs as well.
+	Redact func(URL string) string
+
+	mu  sync.Mutex
+	seq int64
+}
+
+func (t *TraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := atomic.AddInt64(&t.seq, 1)
+	rec := &traceRecord{start: time.Now()}
+
+	// Set up the client trace hooks.
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { rec.mark(&rec.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { rec.mark(&rec.dnsDone) },
+		ConnectStart:         func(string, string) { rec.mark(&rec.connectStart) },
+		ConnectDone:          func(string, string, error) { rec.mark(&rec.connectDone) },
+		TLSHandshakeStart:    func() { rec.mark(&rec.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { rec.mark(&rec.tlsDone) },
+		GotConn:              func(info httptrace.GotConnInfo) { rec.gotConn(info.Reused) },
+		GotFirstResponseByte: func() { rec.mark(&rec.firstByte) },
+	}
+	traced := req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	// Count the bytes sent.
+	if req.Body != nil {
+		traced.Body = &countingReadCloser{ReadCloser: req.Body, n: &rec.sent}
+	}
+
+	// Print the request.
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%v] --> %v %v\n", id, req.Method, t.redactURL(req.URL))
+	t.writeHeaders(&b, id, req.Header)
+	if req.ContentLength > 0 {
+		fmt.Fprintf(&b, "[%v]     Content-Length: %v\n", id, req.ContentLength)
+	}
+	t.write(b.String())
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(traced)
+	if err != nil {
+		t.write(fmt.Sprintf("[%v] <-- error after %v: %v\n", id, since(rec.start), err))
+		return nil, err
+	}
+
+	// Print the response.
+	b.Reset()
+	fmt.Fprintf(&b, "[%v] <-- %v (%v)\n", id, resp.Status, rec.timing())
+	t.writeHeaders(&b, id, resp.Header)
+	t.write(b.String())
+
+	// Print the summary once the response body is closed.
+	resp.Body = &tracedBody{
+		ReadCloser: resp.Body,
+		done: func() {
+			t.write(fmt.Sprintf("[%v] sent %v bytes, received %v bytes in %v\n",
+				id, atomic.LoadInt64(&rec.sent), atomic.LoadInt64(&rec.received), since(rec.start)))
+		},
+		n: &rec.received,
+	}
+	return resp, nil
+}
+
+func (t *TraceTransport) redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.User != nil {
+		redacted.User = url.User("***")
+	}
+	s := redacted.String()
+	if t.Redact != nil {
+		s = t.Redact(s)
+	}
+	return s
+}
+
+func (t *TraceTransport) writeHeaders(b *strings.Builder, id int64, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range header[key] {
+			if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+				// Keep the authentication scheme, mask the credentials.
+				if i := strings.Index(value, " "); i != -1 {
+					value = value[:i] + " ***"
+				} else {
+					value = "***"
+				}
+			} else if t.Redact != nil {
+				value = t.Redact(value)
+			}
+			fmt.Fprintf(b, "[%v]     %v: %v\n", id, key, value)
+		}
+	}
+}
+
+func (t *TraceTransport) write(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.Output, s)
+}
+
+// traceRecord collects the timing of a single request.
+type traceRecord struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	reused       bool
+
+	sent     int64
+	received int64
+}
+
+func (rec *traceRecord) mark(t *time.Time) {
+	rec.mu.Lock()
+	*t = time.Now()
+	rec.mu.Unlock()
+}
+
+func (rec *traceRecord) gotConn(reused bool) {
+	rec.mu.Lock()
+	rec.reused = reused
+	rec.mu.Unlock()
+}
+
+func (rec *traceRecord) timing() string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	var parts []string
+	if !rec.dnsStart.IsZero() && !rec.dnsDone.IsZero() {
+		parts = append(parts, "dns "+round(rec.dnsDone.Sub(rec.dnsStart)).String())
+	}
+	if !rec.connectStart.IsZero() && !rec.connectDone.IsZero() {
+		parts = append(parts, "connect "+round(rec.connectDone.Sub(rec.connectStart)).String())
+	}
+	if !rec.tlsStart.IsZero() && !rec.tlsDone.IsZero() {
+		parts = append(parts, "tls "+round(rec.tlsDone.Sub(rec.tlsStart)).String())
+	}
+	if rec.reused {
+		parts = append(parts, "connection reused")
+	}
+	if !rec.firstByte.IsZero() {
+		parts = append(parts, "first byte "+round(rec.firstByte.Sub(rec.start)).String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func since(t time.Time) time.Duration {
+	return round(time.Since(t))
+}
+
+func round(d time.Duration) time.Duration {
+	return d / time.Microsecond * time.Microsecond
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (rc *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := rc.ReadCloser.Read(p)
+	atomic.AddInt64(rc.n, int64(n))
+	return n, err
+}
+
+type tracedBody struct {
+	io.ReadCloser
+	done func()
+	n    *int64
+	once sync.Once
+}
+
+func (body *tracedBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	atomic.AddInt64(body.n, int64(n))
+	return n, err
+}
+
+func (body *tracedBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(body.done)
+	return err
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package progress implements progress reporting for long-running operations.
+//
+// When the output is a terminal, a single status line is being redrawn.
+// Otherwise a plain line is printed every Interval, so that the progress
+// can be followed in CI logs. The same happens when there are multiple
+// reporters running concurrently on a terminal, which would be overwriting
+// each other's status line otherwise.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unit specifies what is being counted.
+type Unit int
+
+const (
+	Bytes Unit = iota
+	Items
+)
+
+var (
+	// Interval between the lines printed when the output is not a terminal.
+	Interval = 10 * time.Second
+
+	// Interval between the redraws when the output is a terminal.
+	RedrawInterval = 200 * time.Millisecond
+)
+
+// terminal tracks the reporters sharing a terminal.
+type terminal struct {
+	reporters int
+
+	// dirty is set when there is a status line not terminated by a newline.
+	dirty bool
+}
+
+var (
+	terminalsMu sync.Mutex
+	terminals   = make(map[io.Writer]*terminal)
+)
+
+func attachTerminal(out io.Writer) *terminal {
+	terminalsMu.Lock()
+	defer terminalsMu.Unlock()
+
+	term, ok := terminals[out]
+	if !ok {
+		term = new(terminal)
+		terminals[out] = term
+	}
+	term.reporters++
+	return term
+}
+
+func detachTerminal(out io.Writer) {
+	terminalsMu.Lock()
+	defer terminalsMu.Unlock()
+
+	if term := terminals[out]; term != nil {
+		term.reporters--
+		if term.reporters == 0 {
+			delete(terminals, out)
+		}
+	}
+}
+
+// Reporter reports the progress of a single operation.
+type Reporter struct {
+	out   io.Writer
+	tty   bool
+	term  *terminal
+	label string
+	total int64
+	unit  Unit
+
+	mu       sync.Mutex
+	done     int64
+	start    time.Time
+	last     time.Time
+	lastLen  int
+	finished bool
+}
+
+// NewReporter returns a reporter writing to out. total is the expected number
+// of bytes or items, a negative value means that it is not known.
+func NewReporter(out io.Writer, label string, total int64, unit Unit) *Reporter {
+	now := time.Now()
+	r := &Reporter{
+		out:   out,
+		tty:   IsTerminal(out),
+		label: label,
+		total: total,
+		unit:  unit,
+		start: now,
+		last:  now,
+	}
+	if r.tty {
+		r.term = attachTerminal(out)
+	}
+	return r
+}
+
+// Add records n more bytes or items done.
+func (r *Reporter) Add(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.done += n
+	if r.finished {
+		return
+	}
+
+	now := time.Now()
+	interval := Interval
+	if r.tty {
+		interval = RedrawInterval
+	}
+	if now.Sub(r.last) < interval {
+		return
+	}
+	r.last = now
+	r.print(now)
+}
+
+// Finish prints the final status. It is safe to call Finish multiple times.
+func (r *Reporter) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.finished {
+		return
+	}
+	r.finished = true
+	r.print(time.Now())
+	if r.term != nil {
+		detachTerminal(r.out)
+	}
+}
+
+func (r *Reporter) print(now time.Time) {
+	elapsed := now.Sub(r.start)
+
+	parts := []string{r.format(r.done)}
+	if r.total >= 0 {
+		parts[0] += " / " + r.format(r.total)
+		if r.total > 0 {
+			parts = append(parts, fmt.Sprintf("%d%%", r.done*100/r.total))
+		}
+	}
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(r.done) / elapsed.Seconds()
+	}
+	if r.unit == Bytes {
+		parts = append(parts, r.format(int64(rate))+"/s")
+	}
+
+	switch {
+	case r.finished:
+		parts = append(parts, "done in "+formatDuration(elapsed))
+	case r.total > 0 && rate > 0:
+		eta := time.Duration(float64(r.total-r.done) / rate * float64(time.Second))
+		parts = append(parts, "ETA "+formatDuration(eta))
+	}
+
+	if r.term == nil {
+		fmt.Fprintf(r.out, "%v: %v\n", r.label, strings.Join(parts, ", "))
+		return
+	}
+
+	terminalsMu.Lock()
+	defer terminalsMu.Unlock()
+
+	// Fall back to plain lines for good once another reporter shows up,
+	// terminating the status line drawn so far, ours or the other one's.
+	if r.term.reporters > 1 {
+		r.tty = false
+	}
+	if !r.tty {
+		if r.term.dirty {
+			fmt.Fprintln(r.out)
+			r.term.dirty = false
+		}
+		fmt.Fprintf(r.out, "%v: %v\n", r.label, strings.Join(parts, ", "))
+		return
+	}
+
+	// Redraw the status line, padding it to overwrite the previous one.
+	line := fmt.Sprintf("%v  %v", r.label, strings.Join(parts, "  "))
+	padding := ""
+	if n := r.lastLen - len(line); n > 0 {
+		padding = strings.Repeat(" ", n)
+	}
+	r.lastLen = len(line)
+	fmt.Fprintf(r.out, "\r%v%v", line, padding)
+	r.term.dirty = true
+	if r.finished {
+		fmt.Fprintln(r.out)
+		r.term.dirty = false
+	}
+}
+
+func (r *Reporter) format(n int64) string {
+	if r.unit == Items {
+		return fmt.Sprint(n)
+	}
+	return FormatBytes(n)
+}
+
+// FormatBytes formats n using binary prefixes, e.g. 1.5 MiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return (d / time.Millisecond * time.Millisecond).String()
+	}
+	return (d / time.Second * time.Second).String()
+}
+
+// IsTerminal returns true if w is a character device, i.e. a terminal.
+func IsTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Reader reports the bytes read through it using a Reporter. The reporter
+// is finished on EOF or when the reader is closed.
+type Reader struct {
+	r        io.Reader
+	reporter *Reporter
+}
+
+// NewReader returns a reader reporting the progress of reading r.
+func NewReader(r io.Reader, reporter *Reporter) *Reader {
+	return &Reader{r, reporter}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.reporter.Add(int64(n))
+	if err == io.EOF {
+		r.reporter.Finish()
+	}
+	return n, err
+}
+
+// Close closes the underlying reader if it is an io.Closer.
+func (r *Reader) Close() error {
+	r.reporter.Finish()
+	if closer, ok := r.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package semver implements Semantic Versioning 2.0.0 as well as conversion
+// from and to the dotted versions used by Chrome and Windows, which consist of
+// up to four numeric components.
+package semver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, see http://semver.org
+type Version struct {
+	Major, Minor, Patch uint64
+
+	// Prerelease and Build are the dot-separated identifiers
+	// following - and + respectively.
+	Prerelease []string
+	Build      []string
+}
+
+// Parse parses s as a semantic version, e.g. 1.2.3-rc.1+build.5
+func Parse(s string) (*Version, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid version %q: %v", s, reason)
+	}
+
+	rest := s
+	var v Version
+
+	// Split the build metadata and the prerelease identifiers.
+	if i := strings.Index(rest, "+"); i != -1 {
+		build := rest[i+1:]
+		rest = rest[:i]
+		ids, err := parseIdentifiers(build, false)
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+		v.Build = ids
+	}
+	if i := strings.Index(rest, "-"); i != -1 {
+		pre := rest[i+1:]
+		rest = rest[:i]
+		ids, err := parseIdentifiers(pre, true)
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+		v.Prerelease = ids
+	}
+
+	// Parse the version core.
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return nil, invalid("MAJOR.MINOR.PATCH expected")
+	}
+	for i, dst := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		n, err := parseNumber(parts[i])
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+		*dst = n
+	}
+	return &v, nil
+}
+
+// MustParse is like Parse, but it panics on error.
+func MustParse(s string) *Version {
+	v, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// ParseDotted parses a version consisting of one to four numeric components,
+// e.g. Chrome extension version 1.2.3.4. The missing components are set to 0
+// and the fourth component becomes the prerelease identifier: 1.2.3-4
+func ParseDotted(s string) (*Version, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("invalid version %q: too many components", s)
+	}
+
+	var nums [4]uint64
+	for i, part := range parts {
+		n, err := parseNumber(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q: %v", s, err)
+		}
+		nums[i] = n
+	}
+
+	v := &Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}
+	if len(parts) == 4 {
+		v.Prerelease = []string{parts[3]}
+	}
+	return v, nil
+}
+
+// ParseAny parses s as a semantic version, falling back to ParseDotted.
+func ParseAny(s string) (*Version, error) {
+	v, err := Parse(s)
+	if err == nil {
+		return v, nil
+	}
+	if v, err := ParseDotted(s); err == nil {
+		return v, nil
+	}
+	return nil, err
+}
+
+// String returns the semantic version string.
+func (v *Version) String() string {
+	s := fmt.Sprintf("%v.%v.%v", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) != 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) != 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Dotted is the inverse of ParseDotted. It fails unless the prerelease part
+// is empty or a single number. The build metadata is dropped.
+func (v *Version) Dotted() (string, error) {
+	s := fmt.Sprintf("%v.%v.%v", v.Major, v.Minor, v.Patch)
+	switch len(v.Prerelease) {
+	case 0:
+		return s, nil
+	case 1:
+		if _, err := parseNumber(v.Prerelease[0]); err == nil {
+			return s + "." + v.Prerelease[0], nil
+		}
+	}
+	return "", fmt.Errorf("version %v cannot be expressed using numbers only", v)
+}
+
+// IncMajor returns the next major version. Prerelease 2.0.0-rc.1 becomes
+// 2.0.0, otherwise X.Y.Z becomes (X+1).0.0. The build metadata is dropped.
+func (v *Version) IncMajor() *Version {
+	if len(v.Prerelease) != 0 && v.Minor == 0 && v.Patch == 0 {
+		return &Version{Major: v.Major}
+	}
+	return &Version{Major: v.Major + 1}
+}
+
+// IncMinor returns the next minor version, see IncMajor.
+func (v *Version) IncMinor() *Version {
+	if len(v.Prerelease) != 0 && v.Patch == 0 {
+		return &Version{Major: v.Major, Minor: v.Minor}
+	}
+	return &Version{Major: v.Major, Minor: v.Minor + 1}
+}
+
+// IncPatch returns the next patch version, see IncMajor.
+func (v *Version) IncPatch() *Version {
+	if len(v.Prerelease) != 0 {
+		return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	}
+	return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
+
+// IncPrerelease returns the next prerelease version the same way npm does.
+// The last numeric prerelease identifier is incremented, or .0 is appended
+// when there is none. In case id is set and the prerelease does not start
+// with it, the prerelease is reset to id.0 instead. A release version gets
+// its patch version incremented and the prerelease set to id.0, or just 0 when
+// id is empty. The build metadata is dropped.
+func (v *Version) IncPrerelease(id string) (*Version, error) {
+	if id != "" {
+		if _, err := parseIdentifiers(id, true); err != nil {
+			return nil, fmt.Errorf("invalid prerelease identifier %q: %v", id, err)
+		}
+	}
+
+	w := &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	if len(v.Prerelease) == 0 {
+		w.Patch++
+		if id != "" {
+			w.Prerelease = []string{id, "0"}
+		} else {
+			w.Prerelease = []string{"0"}
+		}
+		return w, nil
+	}
+
+	if id != "" && v.Prerelease[0] != id {
+		w.Prerelease = []string{id, "0"}
+		return w, nil
+	}
+
+	w.Prerelease = append([]string(nil), v.Prerelease...)
+	for i := len(w.Prerelease) - 1; i >= 0; i-- {
+		if n, err := parseNumber(w.Prerelease[i]); err == nil {
+			w.Prerelease[i] = strconv.FormatUint(n+1, 10)
+			return w, nil
+		}
+	}
+	w.Prerelease = append(w.Prerelease, "0")
+	return w, nil
+}
+
+// Build number formats, see WithBuildNumber.
+const (
+	BuildNumberComponent  = "component"
+	BuildNumberPrerelease = "prerelease"
+	BuildNumberMetadata   = "metadata"
+)
+
+// WithBuildNumber returns the version string with the build number attached
+// according to format, which is one of
+//   - component  - X.Y.Z.N, a fourth component, which is not semver
+//   - prerelease - X.Y.Z-N, or X.Y.Z-pre.N when there is a prerelease already
+//   - metadata   - X.Y.Z+N, or X.Y.Z+build.N when there is metadata already
+//
+// The fourth component cannot be added to a version with a prerelease or
+// build metadata, component falls back to prerelease or metadata respectively
+// in that case, e.g. 1.2.3-rc.1 becomes 1.2.3-rc.1.N.
+//
+// The version is returned unchanged when number is empty.
+func (v *Version) WithBuildNumber(number, format string) (string, error) {
+	if number == "" {
+		return v.String(), nil
+	}
+
+	if format == "" {
+		format = BuildNumberComponent
+	}
+	if format == BuildNumberComponent {
+		switch {
+		case len(v.Prerelease) != 0:
+			format = BuildNumberPrerelease
+		case len(v.Build) != 0:
+			format = BuildNumberMetadata
+		}
+	}
+
+	w := *v
+	switch format {
+	case BuildNumberComponent:
+		if _, err := parseNumber(number); err != nil {
+			return "", fmt.Errorf("invalid build number %q: %v", number, err)
+		}
+		return fmt.Sprintf("%v.%v", v, number), nil
+
+	case BuildNumberPrerelease:
+		if _, err := parseIdentifiers(number, true); err != nil {
+			return "", fmt.Errorf("invalid build number %q: %v", number, err)
+		}
+		w.Prerelease = append(append([]string(nil), v.Prerelease...), number)
+
+	case BuildNumberMetadata:
+		if _, err := parseIdentifiers(number, false); err != nil {
+			return "", fmt.Errorf("invalid build number %q: %v", number, err)
+		}
+		w.Build = append(append([]string(nil), v.Build...), number)
+
+	default:
+		return "", fmt.Errorf("unknown build number format: %v", format)
+	}
+	return w.String(), nil
+}
+
+// parseNumber parses a numeric version component, leading zeros are invalid.
+func parseNumber(s string) (uint64, error) {
+	switch {
+	case s == "":
+		return 0, errors.New("empty component")
+	case !isNumeric(s):
+		return 0, fmt.Errorf("non-numeric component %q", s)
+	case len(s) > 1 && s[0] == '0':
+		return 0, fmt.Errorf("leading zero in %q", s)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// parseIdentifiers parses dot-separated prerelease or build identifiers.
+// Numeric prerelease identifiers must not contain leading zeros.
+func parseIdentifiers(s string, prerelease bool) ([]string, error) {
+	ids := strings.Split(s, ".")
+	for _, id := range ids {
+		if id == "" {
+			return nil, errors.New("empty identifier")
+		}
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return nil, fmt.Errorf("invalid character %q in identifier %q", c, id)
+			}
+		}
+		if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return nil, fmt.Errorf("leading zero in %q", id)
+		}
+	}
+	return ids, nil
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
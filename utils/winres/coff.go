@@ -0,0 +1,130 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package winres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// COFF machine types and the matching relocation types for image-relative
+// 32-bit addresses, which is what the resource data entries contain.
+var machines = map[string]struct {
+	machine   uint16
+	relocType uint16
+}{
+	"386":   {0x14c, 0x7},  // IMAGE_REL_I386_DIR32NB
+	"amd64": {0x8664, 0x3}, // IMAGE_REL_AMD64_ADDR32NB
+	"arm64": {0xaa64, 0x2}, // IMAGE_REL_ARM64_ADDR32NB
+}
+
+// WriteSyso writes a COFF object file for the given GOARCH with a .rsrc
+// section containing a single resource of the given type, ID and language.
+// The Go linker links the .syso files in the package directory automatically.
+func WriteSyso(w io.Writer, arch string, typ, id, language uint16, data []byte) error {
+	m, ok := machines[arch]
+	if !ok {
+		return fmt.Errorf("unsupported architecture: %v", arch)
+	}
+
+	// The resource directory tree: type -> ID -> language -> data entry.
+	// Every directory has just one entry.
+	var rsrc bytes.Buffer
+	const (
+		dirSize   = 16
+		entrySize = 8
+		dataEntry = 3 * (dirSize + entrySize)
+		dataStart = dataEntry + 16
+	)
+	writeDir := func(id uint16, offset uint32, subdir bool) {
+		binary.Write(&rsrc, binary.LittleEndian, [3]uint32{}) // Characteristics, TimeDateStamp, Version
+		binary.Write(&rsrc, binary.LittleEndian, uint16(0))   // NumberOfNamedEntries
+		binary.Write(&rsrc, binary.LittleEndian, uint16(1))   // NumberOfIdEntries
+		binary.Write(&rsrc, binary.LittleEndian, uint32(id))
+		if subdir {
+			offset |= 0x80000000
+		}
+		binary.Write(&rsrc, binary.LittleEndian, offset)
+	}
+	writeDir(typ, dirSize+entrySize, true)
+	writeDir(id, 2*(dirSize+entrySize), true)
+	writeDir(language, dataEntry, false)
+
+	// IMAGE_RESOURCE_DATA_ENTRY, OffsetToData is relocated by the linker.
+	binary.Write(&rsrc, binary.LittleEndian, uint32(dataStart)) // OffsetToData
+	binary.Write(&rsrc, binary.LittleEndian, uint32(len(data))) // Size
+	binary.Write(&rsrc, binary.LittleEndian, uint32(0))         // CodePage
+	binary.Write(&rsrc, binary.LittleEndian, uint32(0))         // Reserved
+
+	rsrc.Write(data)
+	pad(&rsrc)
+
+	// Lay out the file: header, section header, section data, relocations,
+	// symbol table and string table.
+	const (
+		fileHeaderSize    = 20
+		sectionHeaderSize = 40
+		relocSize         = 10
+	)
+	var (
+		rawDataOffset  = uint32(fileHeaderSize + sectionHeaderSize)
+		relocOffset    = rawDataOffset + uint32(rsrc.Len())
+		symTableOffset = relocOffset + relocSize
+	)
+
+	var buf bytes.Buffer
+	for _, v := range []interface{}{
+		// IMAGE_FILE_HEADER
+		m.machine,
+		uint16(1),      // NumberOfSections
+		uint32(0),      // TimeDateStamp
+		symTableOffset, // PointerToSymbolTable
+		uint32(1),      // NumberOfSymbols
+		uint16(0),      // SizeOfOptionalHeader
+		uint16(0),      // Characteristics
+
+		// IMAGE_SECTION_HEADER
+		[8]byte{'.', 'r', 's', 'r', 'c'},
+		uint32(0),          // VirtualSize
+		uint32(0),          // VirtualAddress
+		uint32(rsrc.Len()), // SizeOfRawData
+		rawDataOffset,      // PointerToRawData
+		relocOffset,        // PointerToRelocations
+		uint32(0),          // PointerToLinenumbers
+		uint16(1),          // NumberOfRelocations
+		uint16(0),          // NumberOfLinenumbers
+		uint32(0x40000040), // INITIALIZED_DATA | MEM_READ
+	} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	buf.Write(rsrc.Bytes())
+
+	for _, v := range []interface{}{
+		// IMAGE_RELOCATION for OffsetToData
+		uint32(dataEntry), // VirtualAddress
+		uint32(0),         // SymbolTableIndex
+		m.relocType,
+
+		// IMAGE_SYMBOL for the section
+		[8]byte{'.', 'r', 's', 'r', 'c'},
+		uint32(0), // Value
+		uint16(1), // SectionNumber
+		uint16(0), // Type
+		uint8(3),  // StorageClass, IMAGE_SYM_CLASS_STATIC
+		uint8(0),  // NumberOfAuxSymbols
+
+		// Empty string table
+		uint32(4),
+	} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package winres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Resource types and IDs.
+const (
+	TypeVersion = 16
+
+	// VersionInfoID is the ID used for the VERSIONINFO resource.
+	VersionInfoID = 1
+)
+
+// Resource memory flags, MOVEABLE | PURE as set by rc.exe.
+const memoryFlags = 0x0030
+
+// WriteRes writes a compiled resource file containing a single resource
+// of the given type, ID and language.
+func WriteRes(w io.Writer, typ, id, language uint16, data []byte) error {
+	var buf bytes.Buffer
+
+	// The file starts with an empty resource marking it as 32-bit.
+	writeResHeader(&buf, 0, 0, 0, 0, 0)
+
+	writeResHeader(&buf, uint32(len(data)), typ, id, memoryFlags, language)
+	buf.Write(data)
+	pad(&buf)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeResHeader writes RESOURCEHEADER with numeric type and name.
+func writeResHeader(buf *bytes.Buffer, size uint32, typ, id, flags, language uint16) {
+	for _, v := range []interface{}{
+		size,       // DataSize
+		uint32(32), // HeaderSize
+		uint16(0xffff), typ,
+		uint16(0xffff), id,
+		uint32(0), // DataVersion
+		flags,     // MemoryFlags
+		language,  // LanguageId
+		uint32(0), // Version
+		uint32(0), // Characteristics
+	} {
+		binary.Write(buf, binary.LittleEndian, v)
+	}
+}
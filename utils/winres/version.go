@@ -0,0 +1,112 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package winres
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tchap/salsa/utils/semver"
+)
+
+// BuildStrategy specifies how the build number is mapped into a four-part
+// Windows file version, every part of which is a 16-bit number.
+type BuildStrategy string
+
+const (
+	// BuildReplace replaces the fourth part: 1.2.3.4 + 92 -> 1.2.3.92
+	BuildReplace BuildStrategy = "replace"
+
+	// BuildAdd adds the build number to the fourth part: 1.2.3.4 + 92 -> 1.2.3.96
+	BuildAdd BuildStrategy = "add"
+
+	// BuildEncode encodes the build number into the third and the fourth part
+	// as the high and the low 16 bits: 1.2 + 70000 -> 1.2.1.4464
+	// The third and the fourth part of the version must be 0.
+	BuildEncode BuildStrategy = "encode"
+)
+
+// ParseFileVersion parses a version into its four 16-bit parts.
+// The accepted forms are a, a.b, a.b.c and a.b.c.d, as well as semantic
+// versions a.b.c-d and a.b.c, with optional build metadata, which is ignored.
+// The missing parts are set to 0.
+func ParseFileVersion(s string) ([4]uint16, error) {
+	var parts [4]uint16
+
+	v, err := semver.ParseAny(s)
+	if err != nil {
+		return parts, err
+	}
+	dotted, err := v.Dotted()
+	if err != nil {
+		return parts, err
+	}
+
+	for i, part := range strings.Split(dotted, ".") {
+		n, err := strconv.ParseUint(part, 10, 16)
+		if err != nil {
+			return parts, fmt.Errorf("invalid version %q: part %v exceeds %v",
+				s, part, math.MaxUint16)
+		}
+		parts[i] = uint16(n)
+	}
+	return parts, nil
+}
+
+// FileVersion parses version using ParseFileVersion and maps the build number
+// into it according to strategy. An empty build number is treated as 0.
+func FileVersion(version, build string, strategy BuildStrategy) ([4]uint16, error) {
+	parts, err := ParseFileVersion(version)
+	if err != nil {
+		return parts, err
+	}
+
+	var n uint64
+	if build != "" {
+		n, err = strconv.ParseUint(build, 10, 32)
+		if err != nil {
+			return parts, fmt.Errorf("invalid build number: %q", build)
+		}
+	}
+
+	overflow := func() ([4]uint16, error) {
+		return parts, fmt.Errorf("build number %v does not fit into version %v using strategy %v",
+			n, version, strategy)
+	}
+
+	switch strategy {
+	case BuildReplace:
+		if n > math.MaxUint16 {
+			return overflow()
+		}
+		parts[3] = uint16(n)
+
+	case BuildAdd:
+		if uint64(parts[3])+n > math.MaxUint16 {
+			return overflow()
+		}
+		parts[3] += uint16(n)
+
+	case BuildEncode:
+		if parts[2] != 0 || parts[3] != 0 {
+			return parts, fmt.Errorf("version %v: the third and the fourth part must be 0 "+
+				"to encode the build number", version)
+		}
+		parts[2] = uint16(n >> 16)
+		parts[3] = uint16(n & 0xffff)
+
+	default:
+		return parts, fmt.Errorf("unknown build strategy: %v", strategy)
+	}
+	return parts, nil
+}
+
+// FormatFileVersion joins the version parts using sep, e.g. 1.2.3.4 or 1,2,3,4
+func FormatFileVersion(parts [4]uint16, sep string) string {
+	return fmt.Sprintf("%v%v%v%v%v%v%v", parts[0], sep, parts[1], sep, parts[2], sep, parts[3])
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package winres
+
+import (
+	"testing"
+)
+
+func TestParseFileVersion(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected [4]uint16
+		ok       bool
+	}{
+		{"1", [4]uint16{1, 0, 0, 0}, true},
+		{"1.2", [4]uint16{1, 2, 0, 0}, true},
+		{"1.2.3", [4]uint16{1, 2, 3, 0}, true},
+		{"1.2.3.4", [4]uint16{1, 2, 3, 4}, true},
+		{"1.2.3-4", [4]uint16{1, 2, 3, 4}, true},
+		{"1.2.3+build.5", [4]uint16{1, 2, 3, 0}, true},
+		{"1.2.3-4+build.5", [4]uint16{1, 2, 3, 4}, true},
+		{"0.0.0.0", [4]uint16{0, 0, 0, 0}, true},
+		{"65535.65535.65535.65535", [4]uint16{65535, 65535, 65535, 65535}, true},
+
+		{"", [4]uint16{}, false},
+		{"1.2.3.4.5", [4]uint16{}, false},
+		{"1.2.3-rc.1", [4]uint16{}, false},
+		{"1.2.3-rc", [4]uint16{}, false},
+		{"1.2.3.x", [4]uint16{}, false},
+		{"1.2.3.4x", [4]uint16{}, false},
+		{"x1.2.3.4", [4]uint16{}, false},
+		{" 1.2.3", [4]uint16{}, false},
+		{"1..3", [4]uint16{}, false},
+		{"1.2.3.", [4]uint16{}, false},
+		{"01.2.3", [4]uint16{}, false},
+		{"-1.2.3", [4]uint16{}, false},
+		{"65536.0.0", [4]uint16{}, false},
+		{"1.2.3.65536", [4]uint16{}, false},
+		{"1.2.3-65536", [4]uint16{}, false},
+	}
+
+	for _, c := range cases {
+		parts, err := ParseFileVersion(c.input)
+		switch {
+		case c.ok && err != nil:
+			t.Errorf("%q: unexpected error: %v", c.input, err)
+		case !c.ok && err == nil:
+			t.Errorf("%q: expected an error, got %v", c.input, parts)
+		case c.ok && parts != c.expected:
+			t.Errorf("%q: expected %v, got %v", c.input, c.expected, parts)
+		}
+	}
+}
+
+func TestFileVersion(t *testing.T) {
+	cases := []struct {
+		version  string
+		build    string
+		strategy BuildStrategy
+		expected [4]uint16
+		ok       bool
+	}{
+		// Replace the fourth part.
+		{"1.2.3", "92", BuildReplace, [4]uint16{1, 2, 3, 92}, true},
+		{"1.2.3.4", "92", BuildReplace, [4]uint16{1, 2, 3, 92}, true},
+		{"1.2.3-4", "92", BuildReplace, [4]uint16{1, 2, 3, 92}, true},
+		{"1.2.3.4", "", BuildReplace, [4]uint16{1, 2, 3, 0}, true},
+		{"1.2.3", "65535", BuildReplace, [4]uint16{1, 2, 3, 65535}, true},
+		{"1.2.3", "65536", BuildReplace, [4]uint16{}, false},
+
+		// Add to the fourth part.
+		{"1.2.3", "92", BuildAdd, [4]uint16{1, 2, 3, 92}, true},
+		{"1.2.3.4", "92", BuildAdd, [4]uint16{1, 2, 3, 96}, true},
+		{"1.2.3-4", "92", BuildAdd, [4]uint16{1, 2, 3, 96}, true},
+		{"1.2.3.4", "", BuildAdd, [4]uint16{1, 2, 3, 4}, true},
+		{"1.2.3.65000", "535", BuildAdd, [4]uint16{1, 2, 3, 65535}, true},
+		{"1.2.3.65000", "536", BuildAdd, [4]uint16{}, false},
+
+		// Encode into the third and the fourth part.
+		{"1.2", "92", BuildEncode, [4]uint16{1, 2, 0, 92}, true},
+		{"1.2", "70000", BuildEncode, [4]uint16{1, 2, 1, 4464}, true},
+		{"1.2.0.0", "4294967295", BuildEncode, [4]uint16{1, 2, 65535, 65535}, true},
+		{"1.2", "", BuildEncode, [4]uint16{1, 2, 0, 0}, true},
+		{"1.2.3", "92", BuildEncode, [4]uint16{}, false},
+		{"1.2.0.4", "92", BuildEncode, [4]uint16{}, false},
+		{"1.2", "4294967296", BuildEncode, [4]uint16{}, false},
+
+		// Invalid input.
+		{"1.2.3", "x", BuildAdd, [4]uint16{}, false},
+		{"1.2.3", "-1", BuildAdd, [4]uint16{}, false},
+		{"1.2.3", "1", "concat", [4]uint16{}, false},
+		{"1.2.3.4.5", "1", BuildAdd, [4]uint16{}, false},
+	}
+
+	for _, c := range cases {
+		parts, err := FileVersion(c.version, c.build, c.strategy)
+		switch {
+		case c.ok && err != nil:
+			t.Errorf("%q + %q (%v): unexpected error: %v", c.version, c.build, c.strategy, err)
+		case !c.ok && err == nil:
+			t.Errorf("%q + %q (%v): expected an error, got %v", c.version, c.build, c.strategy, parts)
+		case c.ok && parts != c.expected:
+			t.Errorf("%q + %q (%v): expected %v, got %v",
+				c.version, c.build, c.strategy, c.expected, parts)
+		}
+	}
+}
+
+func TestFormatFileVersion(t *testing.T) {
+	parts := [4]uint16{1, 2, 3, 4}
+	if s := FormatFileVersion(parts, "."); s != "1.2.3.4" {
+		t.Errorf("expected 1.2.3.4, got %v", s)
+	}
+	if s := FormatFileVersion(parts, ","); s != "1,2,3,4" {
+		t.Errorf("expected 1,2,3,4, got %v", s)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+// Package winres builds Windows VERSIONINFO resources and writes them either
+// as compiled resource (.res) files or as COFF objects (.syso) to be linked
+// by the Go linker, so that no resource compiler is needed.
+package winres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Fixed file info values, see VS_FIXEDFILEINFO.
+const (
+	FileOSWindows32 = 0x4
+
+	FileTypeApp = 0x1
+	FileTypeDLL = 0x2
+
+	FileFlagsMask = 0x3f
+)
+
+// String is a StringFileInfo value.
+type String struct {
+	Key, Value string
+}
+
+// VersionInfo describes a VERSIONINFO resource.
+type VersionInfo struct {
+	FileVersion    [4]uint16
+	ProductVersion [4]uint16
+	FileFlagsMask  uint32
+	FileFlags      uint32
+	FileOS         uint32
+	FileType       uint32
+	FileSubtype    uint32
+
+	// Language and Codepage of the strings, e.g. 0x0409 and 1252.
+	Language uint16
+	Codepage uint16
+
+	// Strings are written in the given order.
+	Strings []String
+}
+
+// Bytes returns the VS_VERSIONINFO structure, which is the resource data.
+func (vi *VersionInfo) Bytes() []byte {
+	var fixed bytes.Buffer
+	for _, v := range []uint32{
+		0xfeef04bd, // dwSignature
+		0x00010000, // dwStrucVersion
+		uint32(vi.FileVersion[0])<<16 | uint32(vi.FileVersion[1]),
+		uint32(vi.FileVersion[2])<<16 | uint32(vi.FileVersion[3]),
+		uint32(vi.ProductVersion[0])<<16 | uint32(vi.ProductVersion[1]),
+		uint32(vi.ProductVersion[2])<<16 | uint32(vi.ProductVersion[3]),
+		vi.FileFlagsMask,
+		vi.FileFlags,
+		vi.FileOS,
+		vi.FileType,
+		vi.FileSubtype,
+		0, // dwFileDateMS
+		0, // dwFileDateLS
+	} {
+		binary.Write(&fixed, binary.LittleEndian, v)
+	}
+
+	strs := make([]*block, 0, len(vi.Strings))
+	for _, s := range vi.Strings {
+		value := utf16z(s.Value)
+		strs = append(strs, &block{
+			key:         s.Key,
+			value:       value,
+			valueLength: uint16(len(value) / 2),
+			text:        true,
+		})
+	}
+
+	var translation bytes.Buffer
+	binary.Write(&translation, binary.LittleEndian, vi.Language)
+	binary.Write(&translation, binary.LittleEndian, vi.Codepage)
+
+	root := &block{
+		key:         "VS_VERSION_INFO",
+		value:       fixed.Bytes(),
+		valueLength: uint16(fixed.Len()),
+		children: []*block{
+			{
+				key:  "StringFileInfo",
+				text: true,
+				children: []*block{
+					{
+						key:      fmt.Sprintf("%04x%04x", vi.Language, vi.Codepage),
+						text:     true,
+						children: strs,
+					},
+				},
+			},
+			{
+				key:  "VarFileInfo",
+				text: true,
+				children: []*block{
+					{
+						key:         "Translation",
+						value:       translation.Bytes(),
+						valueLength: uint16(translation.Len()),
+					},
+				},
+			},
+		},
+	}
+	return root.bytes()
+}
+
+// block is the generic structure all the version information blocks share:
+// wLength, wValueLength, wType, szKey, padding, value, padding and children,
+// every child aligned to 32 bits.
+type block struct {
+	key         string
+	value       []byte
+	valueLength uint16
+	text        bool
+	children    []*block
+}
+
+func (b *block) bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0}) // wLength, filled in below
+	binary.Write(&buf, binary.LittleEndian, b.valueLength)
+	if b.text {
+		binary.Write(&buf, binary.LittleEndian, uint16(1))
+	} else {
+		binary.Write(&buf, binary.LittleEndian, uint16(0))
+	}
+	buf.Write(utf16z(b.key))
+	pad(&buf)
+
+	buf.Write(b.value)
+	for _, child := range b.children {
+		pad(&buf)
+		buf.Write(child.bytes())
+	}
+
+	p := buf.Bytes()
+	binary.LittleEndian.PutUint16(p, uint16(len(p)))
+	return p
+}
+
+// utf16z encodes s as null-terminated little endian UTF-16.
+func utf16z(s string) []byte {
+	var buf bytes.Buffer
+	for _, c := range utf16.Encode([]rune(s)) {
+		binary.Write(&buf, binary.LittleEndian, c)
+	}
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
+
+// pad pads buf to 32 bits.
+func pad(buf *bytes.Buffer) {
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
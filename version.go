@@ -0,0 +1,274 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+
+	// Salsa
+	"github.com/tchap/salsa/utils/ciutil"
+	"github.com/tchap/salsa/utils/semver"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+// Subcommand flags.
+var (
+	versionPreid    string
+	versionManifest string
+	versionGitTag   bool
+)
+
+// Subcommand initialisation and registration.
+func init() {
+	version := &gocli.Command{
+		UsageLine: `
+  version [-preid ID] [-manifest MANIFEST_FILE] [-git_tag]
+          {major|minor|patch|prerelease|VERSION}`,
+		Short: "bump the package version",
+		Long: `
+  Bump the version in package.json in the current working directory, or set it
+  to VERSION, which must be a semantic version. The rest of package.json is left
+  untouched, so the key order and formatting are preserved.
+
+  The bumps work the same way as in npm version:
+    * major      - 1.2.3 -> 2.0.0, 2.0.0-rc.1 -> 2.0.0
+    * minor      - 1.2.3 -> 1.3.0, 1.3.0-rc.1 -> 1.3.0
+    * patch      - 1.2.3 -> 1.2.4, 1.2.4-rc.1 -> 1.2.4
+    * prerelease - 1.2.3 -> 1.2.4-0, 1.2.4-rc.1 -> 1.2.4-rc.2
+  -preid sets the prerelease identifier, e.g. 1.2.3 -> 1.2.4-rc.0, and resets
+  the prerelease when it starts with a different one, 1.2.4-rc.1 -> 1.2.4-beta.0
+
+  -manifest updates the version in a Chrome extension manifest.json as well,
+  converting 1.2.3-4 to 1.2.3.4. Only the versions that can be expressed
+  using four numbers are accepted in that case.
+
+  -git_tag commits the updated files using the new version as the message and
+  tags the commit as v$version, the same way npm version does it. The files
+  are committed into the git repository in the current working directory,
+  the other changes are left alone. The files are restored when they cannot
+  be committed. In case the git binary is not available, the files are not
+  committed and the tag is written directly, pointing to the current HEAD.
+		`,
+		Action: runVersion,
+	}
+	version.Flags.StringVar(&versionPreid, "preid", versionPreid,
+		"prerelease identifier to use")
+	version.Flags.StringVar(&versionManifest, "manifest", versionManifest,
+		"update the version in manifest.json as well")
+	version.Flags.BoolVar(&versionGitTag, "git_tag", versionGitTag,
+		"commit the updated files and tag the commit")
+
+	getApp().MustRegisterSubcommand(version)
+}
+
+// Subcommand handler.
+func runVersion(cmd *gocli.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+
+	// Read the current version.
+	content, err := ioutil.ReadFile(PackageFile)
+	if err != nil {
+		log.Fatalf("Error: failed to read %v: %v", PackageFile, err)
+	}
+
+	var pkg struct {
+		Version string
+	}
+	if err := json.Unmarshal(content, &pkg); err != nil {
+		log.Fatalf("Error: failed to unmarshal %v: %v", PackageFile, err)
+	}
+
+	current, err := semver.Parse(pkg.Version)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// Compute the new version.
+	var next *semver.Version
+	switch args[0] {
+	case "major":
+		next = current.IncMajor()
+	case "minor":
+		next = current.IncMinor()
+	case "patch":
+		next = current.IncPatch()
+	case "prerelease":
+		next, err = current.IncPrerelease(versionPreid)
+	default:
+		next, err = semver.Parse(args[0])
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	version := next.String()
+
+	// Prepare the new content of the files first so that nothing is written
+	// in case any of the files cannot be updated.
+	type update struct {
+		filename string
+		original []byte
+		content  []byte
+	}
+	var updates []update
+
+	original := content
+	content, err = setJSONString(content, "version", version)
+	if err != nil {
+		log.Fatalf("Error: failed to update %v: %v", PackageFile, err)
+	}
+	updates = append(updates, update{PackageFile, original, content})
+
+	if versionManifest != "" {
+		manifestVersion, err := fromPackageJsonVersion(version)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+		original, err := ioutil.ReadFile(versionManifest)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		content, err := setJSONString(original, "version", manifestVersion)
+		if err != nil {
+			log.Fatalf("Error: failed to update %v: %v", versionManifest, err)
+		}
+		updates = append(updates, update{versionManifest, original, content})
+	}
+
+	// Make sure the git tag can be created before anything is written.
+	// The git binary is used to commit and tag the files when available,
+	// otherwise the tag is written directly and points to the current HEAD.
+	tag := "v" + version
+	var haveGit bool
+	if versionGitTag {
+		_, err := exec.LookPath("git")
+		haveGit = err == nil
+		if err := checkGitTag(tag, haveGit); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	// restore writes the original content back into the files written so far.
+	var written []update
+	restore := func(err error) {
+		for _, u := range written {
+			info, statErr := os.Stat(u.filename)
+			if statErr == nil {
+				statErr = ioutil.WriteFile(u.filename, u.original, info.Mode())
+			}
+			if statErr != nil {
+				log.Fatalf("Error: %v\n\nFailed to restore %v either: %v", err, u.filename, statErr)
+			}
+		}
+		log.Fatalf("Error: %v\n\nThe files were restored, the version was not changed.", err)
+	}
+
+	// Write the files.
+	for _, u := range updates {
+		if config.Verbose() || config.Dry() {
+			fmt.Printf("Updating %v ...\n", u.filename)
+		}
+		if config.Dry() {
+			continue
+		}
+
+		info, err := os.Stat(u.filename)
+		if err != nil {
+			restore(err)
+		}
+		if err := ioutil.WriteFile(u.filename, u.content, info.Mode()); err != nil {
+			restore(err)
+		}
+		written = append(written, u)
+	}
+
+	// Commit the files and tag the commit.
+	if versionGitTag {
+		if config.Verbose() || config.Dry() {
+			if haveGit {
+				fmt.Printf("Committing and tagging %v ...\n", tag)
+			} else {
+				fmt.Printf("Tagging %v ...\n", tag)
+			}
+		}
+		if !config.Dry() {
+			if haveGit {
+				var filenames []string
+				for _, u := range updates {
+					filenames = append(filenames, u.filename)
+				}
+				if err := gitCommit(version, filenames); err != nil {
+					restore(err)
+				}
+				err = gitTag(tag)
+			} else {
+				fmt.Printf("Warning: git not found, tagging the current HEAD, the files are not committed\n")
+				err = ciutil.CreateTag(".", tag)
+			}
+			if err != nil {
+				log.Fatalf("Error: failed to create tag %v: %v\n\nThe version was updated to %v, create the tag manually.",
+					tag, err, version)
+			}
+		}
+	}
+
+	fmt.Printf("%v -> %v\n", current, version)
+}
+
+// checkGitTag makes sure tag does not exist yet, using the git binary
+// if haveGit is set and reading the refs directly otherwise.
+func checkGitTag(tag string, haveGit bool) error {
+	if !haveGit {
+		exists, err := ciutil.TagExists(".", tag)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("git tag %v exists already", tag)
+		}
+		return nil
+	}
+
+	err := exec.Command("git", "rev-parse", "-q", "--verify", "refs/tags/"+tag).Run()
+	if err == nil {
+		return fmt.Errorf("git tag %v exists already", tag)
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return err
+	}
+	return nil
+}
+
+// gitCommit commits filenames using message, the other changes are left alone.
+func gitCommit(message string, filenames []string) error {
+	return runGit(append([]string{"commit", "-m", message, "--"}, filenames...)...)
+}
+
+// gitTag tags HEAD as tag.
+func gitTag(tag string) error {
+	return runGit("tag", tag)
+}
+
+func runGit(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %v: %v", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2013 The AUTHORS
+//
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	// Stdlib
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	// Others
+	"github.com/tchap/gocli"
+)
+
+// ExitTimeout is the exit code of wait when the artifact does not appear
+// in time, so that it can be told apart from other errors.
+const ExitTimeout = 3
+
+// Subcommand flags.
+var (
+	waitTag         string
+	waitBranch      string
+	waitArchiver    string        = "tar.gz"
+	waitTimeout     time.Duration = 30 * time.Minute
+	waitInterval    time.Duration = 15 * time.Second
+	waitMaxInterval time.Duration = 2 * time.Minute
+)
+
+// Subcommand initialisation and registration.
+func init() {
+	wait := &gocli.Command{
+		UsageLine: `
+  wait [-tag TAG] [-branch BRANCH] [-archiver {tar.gz|zip}] [-timeout DURATION]
+       [-interval DURATION] [-max_interval DURATION] PROJECT VERSION`,
+		Short: "wait until an artifact is published",
+		Long: `
+  Poll the store using HEAD requests until the archive of VERSION of PROJECT
+  published from BRANCH exists. The archive file name is computed the same way
+  publish computes it, the branch is detected the same way unless -branch is
+  set.
+  VERSION can be a distribution tag, which is resolved on every attempt.
+
+  The polling starts with -interval and the interval grows by half after every
+  attempt, up to -max_interval.
+
+  wait exits with 0 when the archive exists and with 3 when -timeout elapses.
+		`,
+		Action: runWait,
+	}
+	wait.Flags.StringVar(&waitTag, "tag", waitTag,
+		"tag used in the archive file name")
+	wait.Flags.StringVar(&waitBranch, "branch", waitBranch,
+		"branch the archive is published from")
+	wait.Flags.StringVar(&waitArchiver, "archiver", waitArchiver,
+		"archiver used to pack the artifacts")
+	wait.Flags.DurationVar(&waitTimeout, "timeout", waitTimeout,
+		"give up after this duration")
+	wait.Flags.DurationVar(&waitInterval, "interval", waitInterval,
+		"initial polling interval")
+	wait.Flags.DurationVar(&waitMaxInterval, "max_interval", waitMaxInterval,
+		"maximum polling interval")
+
+	getApp().MustRegisterSubcommand(wait)
+}
+
+// Subcommand handler.
+func runWait(cmd *gocli.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		os.Exit(2)
+	}
+	project, version := args[0], args[1]
+
+	if waitInterval <= 0 {
+		log.Fatalln("Error: -interval must be positive")
+	}
+
+	branch := waitBranch
+	if branch == "" {
+		branch = currentBranch()
+	}
+
+	store, client := tagStore(project)
+
+	var (
+		deadline = time.Now().Add(waitTimeout)
+		interval = waitInterval
+	)
+	for {
+		// Resolve the version every time since the tag may change meanwhile.
+		URL, exists, err := func() (string, bool, error) {
+			version, err := resolveVersion(client, store, project, branch, version)
+			if err != nil {
+				return "", false, err
+			}
+
+			filename := archiveFilename(project, waitTag, branch, version, waitArchiver)
+			URL := store.ArtifactURL(project, branch, filename)
+			if config.Verbose() || config.Dry() {
+				fmt.Printf("HEAD %v\n", URL)
+			}
+			if config.Dry() {
+				return URL, true, nil
+			}
+
+			resp, err := client.Head(URL)
+			if err != nil {
+				return URL, false, err
+			}
+			switch {
+			case resp.StatusCode == http.StatusUnauthorized ||
+				resp.StatusCode == http.StatusForbidden:
+				log.Fatalf("Error: HEAD %v: %v", URL, resp.Status)
+			case resp.StatusCode == http.StatusNotFound:
+				return URL, false, nil
+			case resp.StatusCode >= 300:
+				return URL, false, fmt.Errorf("HEAD %v: %v", URL, resp.Status)
+			}
+			return URL, true, nil
+		}()
+
+		switch {
+		case exists:
+			fmt.Printf("Archive found at\n\n  %v\n\n", URL)
+			return
+		case err != nil:
+			// Keep polling, the store may be temporarily unavailable
+			// or the tag may not exist yet.
+			log.Printf("Warning: %v", err)
+		}
+
+		// Wait for the next attempt, the last one is made at the deadline.
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			log.Printf("Error: timed out waiting for version %v of project %v",
+				version, project)
+			os.Exit(ExitTimeout)
+		}
+		if interval < remaining {
+			time.Sleep(interval)
+		} else {
+			time.Sleep(remaining)
+		}
+
+		interval += interval / 2
+		if waitMaxInterval > 0 && interval > waitMaxInterval {
+			interval = waitMaxInterval
+		}
+	}
+}